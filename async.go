@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+)
+
+const (
+	defaultAsyncQueueSize       = 100
+	defaultAsyncShutdownTimeout = 10 * time.Second
+	defaultAsyncRetryLimit      = 10
+	// abandonTimeout bounds the wait for the abandoned chunks to be
+	// written to DeadLetterDir after the shutdown deadline.
+	abandonTimeout = time.Second
+)
+
+type asyncConf struct {
+	queueSize       int
+	shutdownTimeout time.Duration
+	// retryLimit is the number of retries of a chunk.
+	retryLimit int
+}
+
+// getAsyncConfig returns nil when Async is not enabled.
+func getAsyncConfig(async, queueSize, shutdownTimeout, retryLimit string) (*asyncConf, error) {
+	if async == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(async)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Async: %s", async)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	conf := &asyncConf{}
+	if conf.queueSize, err = cwlogs.ParseIntParameter("AsyncQueueSize", queueSize, defaultAsyncQueueSize); err != nil {
+		return nil, err
+	}
+	if conf.queueSize < 1 {
+		return nil, fmt.Errorf("Invalid AsyncQueueSize: %s", queueSize)
+	}
+	if conf.shutdownTimeout, err = cwlogs.ParseDurationParameter("AsyncShutdownTimeout", shutdownTimeout, defaultAsyncShutdownTimeout); err != nil {
+		return nil, err
+	}
+	if conf.retryLimit, err = cwlogs.ParseIntParameter("AsyncRetryLimit", retryLimit, defaultAsyncRetryLimit); err != nil {
+		return nil, err
+	}
+	if conf.retryLimit < 0 {
+		return nil, fmt.Errorf("Invalid AsyncRetryLimit: %s", retryLimit)
+	}
+	return conf, nil
+}
+
+// asyncSender sends the chunks queued by flush in a goroutine, so that
+// slow PutLogEvents calls do not block the Fluent Bit engine. The client
+// and the workers are owned by the goroutine once the sender is started.
+type asyncSender struct {
+	client          *cwlogs.Client
+	workers         *workerPool
+	retry           *cwlogs.RetryConf
+	retryLimit      int
+	shutdownTimeout time.Duration
+
+	chunks chan *chunk
+	// stop is closed when the shutdown deadline has passed.
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAsyncSender(client *cwlogs.Client, workers *workerPool, retry *cwlogs.RetryConf, conf *asyncConf) *asyncSender {
+	s := &asyncSender{
+		client:          client,
+		workers:         workers,
+		retry:           retry,
+		retryLimit:      conf.retryLimit,
+		shutdownTimeout: conf.shutdownTimeout,
+		chunks:          make(chan *chunk, conf.queueSize),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// enqueue reports false when the queue is full.
+func (s *asyncSender) enqueue(c *chunk) bool {
+	select {
+	case s.chunks <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *asyncSender) run() {
+	defer close(s.done)
+	defer s.client.Close()
+	defer s.workers.close()
+
+	for c := range s.chunks {
+		s.send(c)
+	}
+}
+
+// send retries the chunk with backoff until it is delivered or dropped.
+// After retryLimit retries or the shutdown deadline, the chunk is written
+// to DeadLetterDir instead.
+func (s *asyncSender) send(c *chunk) {
+	// cause is the error of the last attempt.
+	cause := fmt.Errorf("shutdown timeout exceeded")
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-s.stop:
+			s.abandon(c, cause, "at shutdown")
+			return
+		default:
+		}
+
+		_, err := c.send(s.client, s.workers)
+		if err == nil {
+			return
+		}
+		cause = err
+		if attempt > s.retryLimit {
+			s.abandon(c, cause, fmt.Sprintf("after %d retries", s.retryLimit))
+			return
+		}
+		delay := s.retry.Backoff(attempt)
+		fmt.Printf("error sending message for CloudWatchLogs: %v (retrying in %v)\n", err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			s.abandon(c, cause, "at shutdown")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// abandon writes the events of c, which could not be sent, to
+// DeadLetterDir when it is specified. The batches of c which were delivered
+// or dropped in a previous attempt are skipped, so that they are not sent
+// twice by a replay. reason tells when c was given up in the log.
+func (s *asyncSender) abandon(c *chunk, cause error, reason string) {
+	fmt.Printf("Giving up a chunk of %d events %s. error: %v\n", c.len(), reason, cause)
+	for _, token := range c.destinations {
+		events := s.client.UndeliveredEvents(c.id, token, c.events[token])
+		if len(events) == 0 {
+			continue
+		}
+		if err := s.client.WriteDeadLetters(token, events, cause); err != nil {
+			fmt.Printf("Discarded %d events to %s/%s %s. error: %v\n", len(events), token.LogGroup, token.LogStream, reason, err)
+		}
+	}
+	s.client.ClearDelivered(c.id)
+}
+
+// shutdown sends the queued chunks, and waits for them until the shutdown
+// deadline. The chunks which are still queued after the deadline are
+// abandoned.
+func (s *asyncSender) shutdown() {
+	close(s.chunks)
+
+	timer := time.NewTimer(s.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+		return
+	case <-timer.C:
+	}
+
+	fmt.Printf("Async queue was not drained in %v. Abandoning %d queued chunks.\n", s.shutdownTimeout, len(s.chunks))
+	close(s.stop)
+	// A PutLogEvents call may still be in flight.
+	select {
+	case <-s.done:
+	case <-time.After(abandonTimeout):
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAsyncConfig(t *testing.T) {
+	conf, err := getAsyncConfig("", "", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, conf)
+
+	conf, err = getAsyncConfig("false", "10", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, conf)
+
+	conf, err = getAsyncConfig("true", "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultAsyncQueueSize, conf.queueSize)
+	assert.Equal(t, defaultAsyncShutdownTimeout, conf.shutdownTimeout)
+	assert.Equal(t, defaultAsyncRetryLimit, conf.retryLimit)
+
+	conf, err = getAsyncConfig("1", "10", "30s", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, conf.queueSize)
+	assert.Equal(t, 30*time.Second, conf.shutdownTimeout)
+	assert.Equal(t, 0, conf.retryLimit)
+
+	_, err = getAsyncConfig("sometimes", "", "", "")
+	assert.Error(t, err)
+	_, err = getAsyncConfig("true", "0", "", "")
+	assert.Error(t, err)
+	_, err = getAsyncConfig("true", "", "soon", "")
+	assert.Error(t, err)
+	_, err = getAsyncConfig("true", "", "", "-1")
+	assert.Error(t, err)
+}
+
+func TestPluginFlusherAsync(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		params:           map[string]string{"Async": "true", "AsyncQueueSize": "1"},
+		putStarted:       make(chan struct{}, 10),
+		putGate:          make(chan struct{}),
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+
+	// The first chunk is being sent, and the second one is queued.
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	<-testplugin.putStarted
+	testplugin.position = 0
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+
+	// Fluent Bit retries the chunk while the queue is full.
+	testplugin.position = 0
+	assert.Equal(t, output.FLB_RETRY, flush(nil, nil, 0, ""))
+
+	// The queue is drained at exit.
+	close(testplugin.putGate)
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+	assert.Equal(t, 2, testplugin.putCalls)
+	assert.Len(t, testplugin.events, 2)
+}
+
+func TestPluginFlusherAsyncAbandonsChunksAtShutdownDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	putErrors := make(map[int]error)
+	for i := 1; i <= 1000; i++ {
+		putErrors[i] = errors.New("connection reset")
+	}
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors:        putErrors,
+		params: map[string]string{
+			"Async": "true", "AsyncShutdownTimeout": "50ms", "AsyncRetryLimit": "1000", "DeadLetterDir": dir,
+			"RetryBaseDelay": "1ms", "RetryMaxDelay": "5ms",
+		},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	testplugin.position = 0
+	testplugin.records[0].data = map[interface{}]interface{}{"mykey": "othervalue"}
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+
+	start := time.Now()
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, testplugin.putCalls > 1)
+	assert.Len(t, testplugin.events, 0)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "dead-letter-*.ndjson"))
+	assert.Len(t, files, 1)
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	var events []cwlogs.DeadLetterEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event cwlogs.DeadLetterEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		events = append(events, event)
+	}
+	assert.Len(t, events, 2)
+	assert.Equal(t, `{"mykey":"myvalue"}`, events[0].Message)
+	assert.Equal(t, "connection reset", events[0].ErrorMessage)
+	// The second chunk was still queued.
+	assert.Equal(t, `{"mykey":"othervalue"}`, events[1].Message)
+	assert.Equal(t, "shutdown timeout exceeded", events[1].ErrorMessage)
+}
+
+func TestPluginFlusherAsyncGivesUpChunkAfterRetryLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	putErrors := make(map[int]error)
+	for i := 1; i <= 1000; i++ {
+		putErrors[i] = errors.New("connection reset")
+	}
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors:        putErrors,
+		params: map[string]string{
+			"Async": "true", "AsyncRetryLimit": "2", "AsyncShutdownTimeout": "10s", "DeadLetterDir": dir,
+			"RetryMaxAttempts": "0", "RetryBaseDelay": "1ms", "RetryMaxDelay": "5ms",
+		},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+
+	// The chunk is given up before the shutdown deadline.
+	start := time.Now()
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 3, testplugin.putCalls)
+	assert.Len(t, testplugin.events, 0)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "dead-letter-*.ndjson"))
+	assert.Len(t, files, 1)
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	var event cwlogs.DeadLetterEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, `{"mykey":"myvalue"}`, event.Message)
+	assert.Equal(t, "connection reset", event.ErrorMessage)
+}
+
+func TestPluginFlusherAsyncGivesUpOnlyUndeliveredBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The first batch is accepted, and the second one always fails.
+	putErrors := make(map[int]error)
+	for i := 2; i <= 1000; i++ {
+		putErrors[i] = errors.New("connection reset")
+	}
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors:        putErrors,
+		params: map[string]string{
+			"Async": "true", "AsyncRetryLimit": "1", "DeadLetterDir": dir,
+			"RetryMaxAttempts": "0", "RetryBaseDelay": "1ms", "RetryMaxDelay": "5ms",
+		},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	// The events span more than 24 hours, so that they are sent in two
+	// batches.
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "first"})
+	testplugin.addrecord(0, output.FLBTime{Time: ts.Add(25 * time.Hour)}, map[interface{}]interface{}{"mykey": "second"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+	assert.Len(t, testplugin.events, 1)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "dead-letter-*.ndjson"))
+	assert.Len(t, files, 1)
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 1)
+	var event cwlogs.DeadLetterEvent
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, `{"mykey":"second"}`, event.Message)
+}
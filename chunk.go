@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+)
+
+// chunk holds the events of a flush for each (logGroup, logStream) pair,
+// which are sent in the order of destinations.
+type chunk struct {
+	destinations []cwlogs.UpdateToken
+	events       map[cwlogs.UpdateToken][]*cloudwatchlogs.InputLogEvent
+	// id is set when the chunk is sent for the first time.
+	id cwlogs.ChunkID
+}
+
+func newChunk() *chunk {
+	return &chunk{events: make(map[cwlogs.UpdateToken][]*cloudwatchlogs.InputLogEvent)}
+}
+
+func (c *chunk) add(token cwlogs.UpdateToken, event *cloudwatchlogs.InputLogEvent) {
+	if _, ok := c.events[token]; !ok {
+		c.destinations = append(c.destinations, token)
+	}
+	c.events[token] = append(c.events[token], event)
+}
+
+func (c *chunk) len() int {
+	n := 0
+	for _, events := range c.events {
+		n += len(events)
+	}
+	return n
+}
+
+// send makes one or more PutLogEvents calls per (logGroup, logStream)
+// pair, with workers when it is not nil. It reports whether a batch was
+// dropped, and returns an error when the chunk should be sent again.
+func (c *chunk) send(client *cwlogs.Client, workers *workerPool) (bool, error) {
+	if c.id == (cwlogs.ChunkID{}) {
+		c.id = cwlogs.DigestChunk(c.destinations, c.events)
+	}
+	var dropped bool
+	var err error
+	if workers != nil {
+		dropped, err = workers.send(c)
+	} else {
+		for _, token := range c.destinations {
+			var droppedStream bool
+			droppedStream, err = sendStream(client, c.id, token, c.events[token])
+			dropped = dropped || droppedStream
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return dropped, err
+	}
+	// The whole chunk has been delivered or dropped.
+	client.ClearDelivered(c.id)
+	return dropped, nil
+}
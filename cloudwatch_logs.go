@@ -2,6 +2,7 @@ package main
 
 import "github.com/aws/aws-sdk-go/aws"
 import "github.com/aws/aws-sdk-go/aws/credentials"
+import "github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
 
 import (
 	"fmt"
@@ -12,49 +13,33 @@ type cloudwatchLogsConfig struct {
 	credentials      *credentials.Credentials
 	logGroupName     *string
 	logStreamName    *string
+	logStreamPrefix  *string
+	logGroupTmpl     *nameTemplate
+	logStreamTmpl    *nameTemplate
 	region           *string
 	autoCreateStream bool
 }
 
 type CloudWatchLogsCredential interface {
-	GetCredentials(accessID, secretkey, credentials string) (*credentials.Credentials, error)
+	GetCredentials(opts *cwlogs.CredentialOptions) (*credentials.Credentials, error)
 }
 
 type cloudwatchLogsPluginConfig struct{}
 
 var cloudwatchLogsCreds CloudWatchLogsCredential = &cloudwatchLogsPluginConfig{}
 
-func (c *cloudwatchLogsPluginConfig) GetCredentials(accessKeyID, secretKey, credential string) (*credentials.Credentials, error) {
-	var creds *credentials.Credentials
-	if credential != "" {
-		creds = credentials.NewSharedCredentials(credential, "default")
-		if _, err := creds.Get(); err != nil {
-			fmt.Println("[SharedCredentials] ERROR:", err)
-		} else {
-			return creds, nil
-		}
-	} else if !(accessKeyID == "" && secretKey == "") {
-		creds = credentials.NewStaticCredentials(accessKeyID, secretKey, "")
-		if _, err := creds.Get(); err != nil {
-			fmt.Println("[StaticCredentials] ERROR:", err)
-		} else {
-			return creds, nil
-		}
-	} else {
-		creds = credentials.NewEnvCredentials()
-		if _, err := creds.Get(); err != nil {
-			fmt.Println("[EnvCredentials] ERROR:", err)
-		} else {
-			return creds, nil
-		}
-	}
-
-	return nil, fmt.Errorf("Failed to create credentials")
+func (c *cloudwatchLogsPluginConfig) GetCredentials(opts *cwlogs.CredentialOptions) (*credentials.Credentials, error) {
+	return cwlogs.GetCredentials(opts)
 }
 
-func getCloudWatchLogsConfig(accessID, secretKey, credential, logGroupName, logStreamName, region, autoCreateStream string) (*cloudwatchLogsConfig, error) {
+func getCloudWatchLogsConfig(credentialOpts *cwlogs.CredentialOptions, logGroupName, logStreamName, logStreamPrefix, logGroupTemplate, logStreamTemplate, region, autoCreateStream string) (*cloudwatchLogsConfig, error) {
 	conf := &cloudwatchLogsConfig{}
-	creds, err := cloudwatchLogsCreds.GetCredentials(accessID, secretKey, credential)
+	if region == "" {
+		// The region of the profile is used when Region is not specified.
+		region = cwlogs.ProfileRegion(credentialOpts)
+		credentialOpts.Region = region
+	}
+	creds, err := cloudwatchLogsCreds.GetCredentials(credentialOpts)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create credentials")
 	}
@@ -65,14 +50,32 @@ func getCloudWatchLogsConfig(accessID, secretKey, credential, logGroupName, logS
 	}
 	conf.logGroupName = aws.String(logGroupName)
 
-	if logStreamName == "" {
-		return nil, fmt.Errorf("Cannot specify empty string to logStreamName")
+	if logStreamName == "" && logStreamPrefix == "" {
+		return nil, fmt.Errorf("Cannot specify empty string to both logStreamName and logStreamPrefix")
 	}
 	conf.logStreamName = aws.String(logStreamName)
+	conf.logStreamPrefix = aws.String(logStreamPrefix)
+
+	if logGroupTemplate != "" {
+		conf.logGroupTmpl, err = parseNameTemplate(logGroupTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if logStreamTemplate != "" {
+		conf.logStreamTmpl, err = parseNameTemplate(logStreamTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	if region == "" {
 		return nil, fmt.Errorf("Cannot specify empty string to region")
 	}
+	if err := credentialOpts.Endpoints.CheckRegion(region); err != nil {
+		return nil, err
+	}
 	conf.region = aws.String(region)
 
 	if autoCreateStream == "" {
@@ -1,13 +1,17 @@
 package main
 
 import (
-	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
-	// "time"
+
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGetS3ConfigStaticCredentials(t *testing.T) {
-	conf, err := getCloudWatchLogsConfig("exampleaccessID", "examplesecretkey", "", "examplelogGroup", "exampleLogstream", "exampleregion", "")
+	conf, err := getCloudWatchLogsConfig(&cwlogs.CredentialOptions{AccessKeyID: "exampleaccessID", SecretAccessKey: "examplesecretkey"}, "examplelogGroup", "exampleLogstream", "", "", "", "exampleregion", "")
 	if err != nil {
 		t.Fatalf("failed test %#v", err)
 	}
@@ -21,7 +25,7 @@ func TestGetS3ConfigStaticCredentials(t *testing.T) {
 
 func TestGetS3ConfigSharedCredentials(t *testing.T) {
 	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
-	conf, err := getCloudWatchLogsConfig("", "", "examplecredentials", "examplelogGroup", "exampleLogstream", "exampleregion", "")
+	conf, err := getCloudWatchLogsConfig(&cwlogs.CredentialOptions{Credential: "examplecredentials"}, "examplelogGroup", "exampleLogstream", "", "", "", "exampleregion", "")
 	if err != nil {
 		t.Fatalf("failed test %#v", err)
 	}
@@ -32,3 +36,53 @@ func TestGetS3ConfigSharedCredentials(t *testing.T) {
 	assert.Equal(t, "exampleregion", *conf.region, "Specify region name")
 	assert.Equal(t, true, conf.autoCreateStream, "Specify autocreatestream flag")
 }
+
+func TestGetCloudWatchLogsConfigTemplates(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	conf, err := getCloudWatchLogsConfig(&cwlogs.CredentialOptions{Credential: "examplecredentials"}, "examplelogGroup", "exampleLogstream", "", "/eks/$kubernetes['namespace_name']", "$kubernetes['pod_name']", "exampleregion", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.NotNil(t, conf.logGroupTmpl, "logGroup template not to be nil")
+	assert.NotNil(t, conf.logStreamTmpl, "logStream template not to be nil")
+
+	_, err = getCloudWatchLogsConfig(&cwlogs.CredentialOptions{Credential: "examplecredentials"}, "examplelogGroup", "exampleLogstream", "", "/eks/$kubernetes['namespace_name'", "", "exampleregion", "")
+	assert.Error(t, err)
+}
+
+func TestGetCloudWatchLogsConfigProfileRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sharedconfig")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	credentialsFile := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(credentialsFile, []byte("[production]\nregion = ap-northeast-1\n"), 0600)
+
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	opts := &cwlogs.CredentialOptions{Credential: credentialsFile, Profile: "production"}
+	conf, err := getCloudWatchLogsConfig(opts, "examplelogGroup", "exampleLogstream", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "ap-northeast-1", *conf.region)
+
+	// Region takes precedence over the profile.
+	conf, err = getCloudWatchLogsConfig(opts, "examplelogGroup", "exampleLogstream", "", "", "", "us-west-1", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "us-west-1", *conf.region)
+}
+
+func TestGetCloudWatchLogsConfigFIPSRegion(t *testing.T) {
+	endpoints, err := cwlogs.GetEndpointConfig("", "", "true", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	opts := &cwlogs.CredentialOptions{Credential: "examplecredentials", Endpoints: endpoints}
+	_, err = getCloudWatchLogsConfig(opts, "examplelogGroup", "exampleLogstream", "", "", "", "us-gov-west-1", "")
+	assert.NoError(t, err)
+	_, err = getCloudWatchLogsConfig(opts, "examplelogGroup", "exampleLogstream", "", "", "", "eu-west-1", "")
+	assert.EqualError(t, err, "UseFIPS is not available in region eu-west-1: FIPS endpoints are provided only in the US and GovCloud (US) regions")
+}
@@ -0,0 +1,151 @@
+// Command cloudwatch-logs-replay sends the events which the plugin wrote to
+// DeadLetterDir to a logStream again.
+//
+//	cloudwatch-logs-replay -log-group mygroup -log-stream mystream /var/lib/fluent-bit/dead-letter
+//
+// The credentials, the endpoints and the retries are configured in the same
+// way as the plugin.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+)
+
+func main() {
+	var (
+		logGroupName     = flag.String("log-group", "", "logGroup name to send the events to (required)")
+		logStreamName    = flag.String("log-stream", "", "logStream name to send the events to (required)")
+		autoCreateStream = flag.Bool("auto-create-stream", true, "create the logGroup and the logStream when they do not exist")
+		batchSize        = flag.Int("batch-size", cwlogs.MaxBatchEvents, "maximum number of events per PutLogEvents call")
+		rate             = flag.Float64("rate", 5, "maximum number of PutLogEvents calls per second")
+		progress         = flag.Duration("progress", 5*time.Second, "interval of progress reports")
+		dryRun           = flag.Bool("dry-run", false, "read and batch the events without sending them")
+
+		region          = flag.String("region", "", "region of CloudWatch Logs, or the region of the profile")
+		credential      = flag.String("credential", "", "path of the AWS shared credentials file")
+		profile         = flag.String("profile", "", "profile of the AWS shared credentials and config")
+		credentialChain = flag.String("credential-chain", "", "comma separated credential providers tried in order, except static")
+		process         = flag.String("credential-process", "", "command which prints credentials")
+		processTimeout  = flag.Duration("credential-process-timeout", cwlogs.DefaultCredentialProcessTimeout, "time limit of credential-process")
+		ec2Metadata     = flag.String("ec2-metadata-endpoint", "", "endpoint URL of the EC2 instance metadata service")
+		roleARN         = flag.String("role-arn", "", "ARN of the IAM role to assume")
+		externalID      = flag.String("external-id", "", "external ID to assume the role")
+		roleSessionName = flag.String("role-session-name", "", "session name of the assumed role")
+		endpoint        = flag.String("endpoint", "", "endpoint URL of CloudWatch Logs")
+		stsEndpoint     = flag.String("sts-endpoint", "", "endpoint URL of STS")
+		useFIPS         = flag.String("use-fips", "", "use the FIPS endpoints of CloudWatch Logs and STS")
+		httpProxy       = flag.String("http-proxy", "", "URL of the HTTP proxy")
+		noProxy         = flag.String("no-proxy", "", "comma separated hosts, domains and CIDR blocks which bypass the proxy")
+		caBundle        = flag.String("ca-bundle", "", "path of the PEM file of additional CA certificates")
+		retryPolicy     = flag.String("retry-policy", "", "comma separated <error code>=<action> which overrides the default classification")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <dead letter file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *logGroupName == "" || *logStreamName == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *batchSize < 1 || *batchSize > cwlogs.MaxBatchEvents {
+		exit(fmt.Errorf("Invalid batch-size: %d", *batchSize))
+	}
+	if *rate <= 0 {
+		exit(fmt.Errorf("Invalid rate: %v", *rate))
+	}
+
+	files, err := deadLetterFiles(flag.Args())
+	if err != nil {
+		exit(err)
+	}
+
+	r := &replayer{
+		token:            cwlogs.UpdateToken{LogGroup: *logGroupName, LogStream: *logStreamName},
+		batchSize:        *batchSize,
+		interval:         time.Duration(float64(time.Second) / *rate),
+		progressInterval: *progress,
+		dryRun:           *dryRun,
+		out:              os.Stdout,
+	}
+	if !*dryRun {
+		endpoints, err := cwlogs.GetEndpointConfig(*endpoint, *stsEndpoint, *useFIPS, "")
+		if err != nil {
+			exit(err)
+		}
+		httpClientConfig, err := cwlogs.GetHTTPClientConfig(*httpProxy, *noProxy, *caBundle, "", "", "", "", "")
+		if err == nil {
+			endpoints.HTTPClient, err = httpClientConfig.Client()
+		}
+		if err != nil {
+			exit(err)
+		}
+		chain, err := cwlogs.ParseCredentialChain(*credentialChain)
+		if err != nil {
+			exit(err)
+		}
+		for _, name := range chain {
+			// Access keys are not accepted on the command line, where they
+			// would be visible to the other users. Use env instead.
+			if name == "static" {
+				exit(fmt.Errorf("Unsupported credential provider in credential-chain: %s", name))
+			}
+		}
+		if *processTimeout <= 0 {
+			exit(fmt.Errorf("Invalid credential-process-timeout: %v", *processTimeout))
+		}
+		retry, err := cwlogs.GetRetryConfig(*retryPolicy, "", "", "")
+		if err != nil {
+			exit(err)
+		}
+
+		opts := &cwlogs.CredentialOptions{
+			Credential:          *credential,
+			Profile:             *profile,
+			EC2MetadataEndpoint: *ec2Metadata,
+			Region:              *region,
+			Endpoints:           endpoints,
+			CredentialChain:     chain,
+
+			CredentialProcess:        *process,
+			CredentialProcessTimeout: *processTimeout,
+		}
+		if opts.Region == "" {
+			opts.Region = cwlogs.ProfileRegion(opts)
+		}
+		if opts.Region == "" {
+			exit(fmt.Errorf("Cannot specify empty string to region"))
+		}
+		if err := endpoints.CheckRegion(opts.Region); err != nil {
+			exit(err)
+		}
+		creds, err := cwlogs.GetCredentials(opts)
+		if err != nil {
+			exit(err)
+		}
+		if assumeRole := cwlogs.GetAssumeRoleConfig(*roleARN, *externalID, *roleSessionName, endpoints); assumeRole != nil {
+			creds = assumeRole.Credentials(creds, opts.Region)
+		}
+
+		sessConfig := endpoints.Config(cwlogs.ServiceCloudWatchLogs, opts.Region)
+		sessConfig.Credentials = creds
+		r.client = cwlogs.NewClient(&cwlogs.SDKAPI{}, cloudwatchlogs.New(session.New(sessConfig)), *autoCreateStream, retry, "", nil)
+	}
+
+	if _, err := r.replay(files); err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+	"github.com/json-iterator/go"
+)
+
+// maxLineSize is the limit of a dead letter line. The message of an event
+// is at most 1 MiB, which can be six times as large when it is escaped in
+// JSON. The longer lines are skipped.
+var maxLineSize = 16 * 1024 * 1024
+
+// replayer sends the events of dead letter files to a logStream.
+type replayer struct {
+	client    *cwlogs.Client
+	token     cwlogs.UpdateToken
+	batchSize int
+	// interval is the minimum time between PutLogEvents calls.
+	interval time.Duration
+	// progressInterval is the minimum time between progress reports.
+	progressInterval time.Duration
+	dryRun           bool
+	out              io.Writer
+}
+
+// replayStats holds the progress of a replay.
+type replayStats struct {
+	events  int
+	sent    int
+	dropped int
+	batches int
+	skipped int
+}
+
+// deadLetterFiles expands the directories in paths to the dead letter files
+// in them, which are sorted in the order of creation.
+func deadLetterFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, cwlogs.DeadLetterFilePrefix+"*"+cwlogs.DeadLetterFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// readEvents reads the events of a dead letter file. It returns the number
+// of the lines which are not valid dead letter events or are too long.
+func readEvents(path string) ([]*cloudwatchlogs.InputLogEvent, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var events []*cloudwatchlogs.InputLogEvent
+	skipped := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == errLineTooLong {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("Failed to read %s: %v", path, err)
+		}
+		if len(line) == 0 {
+			continue
+		}
+		var event cwlogs.DeadLetterEvent
+		if err := jsoniter.Unmarshal(line, &event); err != nil || event.Message == "" {
+			skipped++
+			continue
+		}
+		events = append(events, &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(event.Message),
+			Timestamp: aws.Int64(event.Timestamp),
+		})
+	}
+	return events, skipped, nil
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a line without the line terminator. A line longer than
+// maxLineSize is read through and errLineTooLong is returned.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		fragment, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			if err == io.EOF && (line != nil || tooLong) {
+				break
+			}
+			return nil, err
+		}
+		if !tooLong {
+			if len(line)+len(fragment) > maxLineSize {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, fragment...)
+			}
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	if tooLong {
+		return nil, errLineTooLong
+	}
+	return line, nil
+}
+
+// batches splits events into the batches of PutLogEvents which have at most
+// batchSize events.
+func (r *replayer) batches(events []*cloudwatchlogs.InputLogEvent) [][]*cloudwatchlogs.InputLogEvent {
+	var batches [][]*cloudwatchlogs.InputLogEvent
+	for _, batch := range cwlogs.SplitBatches(events) {
+		for len(batch) > r.batchSize {
+			batches = append(batches, batch[:r.batchSize])
+			batch = batch[r.batchSize:]
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// replay sends the events of files. It stops at the first batch which
+// could not be delivered, so that the events which have been sent are
+// reported.
+func (r *replayer) replay(files []string) (*replayStats, error) {
+	stats := &replayStats{}
+	var events []*cloudwatchlogs.InputLogEvent
+	for _, file := range files {
+		fileEvents, skipped, err := readEvents(file)
+		if err != nil {
+			return stats, err
+		}
+		if skipped > 0 {
+			fmt.Fprintf(r.out, "Skipped %d invalid or too long lines in %s\n", skipped, file)
+		}
+		events = append(events, fileEvents...)
+		stats.skipped += skipped
+	}
+	stats.events = len(events)
+
+	batches := r.batches(events)
+	if r.dryRun {
+		for i, batch := range batches {
+			fmt.Fprintf(r.out, "Batch %d: %d events from %s to %s\n", i+1, len(batch),
+				eventTime(batch[0]).Format(time.RFC3339), eventTime(batch[len(batch)-1]).Format(time.RFC3339))
+		}
+		fmt.Fprintf(r.out, "Dry run: %d events in %d batches would be sent to %s/%s\n",
+			stats.events, len(batches), r.token.LogGroup, r.token.LogStream)
+		return stats, nil
+	}
+
+	r.client.PrepareLogGroup(r.token.LogGroup)
+	r.client.PrepareLogStream(r.token.LogGroup, r.token.LogStream)
+
+	var last, reported time.Time
+	for _, batch := range batches {
+		if wait := r.interval - time.Since(last); !last.IsZero() && wait > 0 {
+			time.Sleep(wait)
+		}
+		last = time.Now()
+
+		// The replay stops at the first failure, so that the batches are
+		// not tracked as a chunk.
+		dropped, err := r.client.PutLogEvents(cwlogs.ChunkID{}, r.token, batch)
+		if err != nil {
+			r.report(stats)
+			return stats, err
+		}
+		stats.batches++
+		if dropped {
+			stats.dropped += len(batch)
+		} else {
+			stats.sent += len(batch)
+		}
+		if time.Since(reported) >= r.progressInterval {
+			r.report(stats)
+			reported = time.Now()
+		}
+	}
+	r.report(stats)
+
+	return stats, nil
+}
+
+func (r *replayer) report(stats *replayStats) {
+	done := stats.sent + stats.dropped
+	percent := 100.0
+	if stats.events > 0 {
+		percent = float64(done) * 100 / float64(stats.events)
+	}
+	fmt.Fprintf(r.out, "Replayed %d/%d events (%.1f%%) in %d batches to %s/%s, %d dropped\n",
+		done, stats.events, percent, stats.batches, r.token.LogGroup, r.token.LogStream, stats.dropped)
+}
+
+func eventTime(event *cloudwatchlogs.InputLogEvent) time.Time {
+	return time.Unix(0, *event.Timestamp*int64(time.Millisecond)).UTC()
+}
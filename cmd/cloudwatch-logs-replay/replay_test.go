@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI keeps the batches put into the logStream. putErrors holds the
+// error of the nth Put call.
+type fakeAPI struct {
+	batches   [][]string
+	created   []string
+	putErrors map[int]error
+}
+
+func (f *fakeAPI) Put(client *cloudwatchlogs.CloudWatchLogs, logEvents []*cloudwatchlogs.InputLogEvent, logGroupName, logStreamName, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if err, ok := f.putErrors[len(f.batches)+1]; ok {
+		return nil, err
+	}
+	var batch []string
+	for _, event := range logEvents {
+		batch = append(batch, *event.Message)
+	}
+	f.batches = append(f.batches, batch)
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("nexttoken")}, nil
+}
+
+func (f *fakeAPI) CheckLogGroupsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) bool {
+	return false
+}
+
+func (f *fakeAPI) CheckLogStreamsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) (bool, string) {
+	return false, ""
+}
+
+func (f *fakeAPI) CreateLogGroup(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) error {
+	f.created = append(f.created, logGroupName)
+	return nil
+}
+
+func (f *fakeAPI) CreateLogStream(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) error {
+	f.created = append(f.created, logGroupName+"/"+logStreamName)
+	return nil
+}
+
+// writeDeadLetterFile writes the messages with timestamps which start at
+// ts and increase by a second.
+func writeDeadLetterFile(t *testing.T, path string, ts time.Time, messages ...string) {
+	var lines []string
+	for i, message := range messages {
+		line, err := jsoniter.MarshalToString(cwlogs.DeadLetterEvent{
+			LogGroup:     "examplegroup",
+			LogStream:    "examplestream",
+			ErrorCode:    "ThrottlingException",
+			ErrorMessage: "Rate exceeded",
+			FailedAt:     ts.Format(time.RFC3339Nano),
+			Timestamp:    aws.TimeUnixMilli(ts.Add(time.Duration(i) * time.Second)),
+			Message:      message,
+		})
+		if err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+}
+
+func TestDeadLetterFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	newer := filepath.Join(dir, "dead-letter-20190310T101113.000000000.ndjson")
+	older := filepath.Join(dir, "dead-letter-20190310T101112.000000000.ndjson")
+	other := filepath.Join(dir, "archive.ndjson")
+	writeDeadLetterFile(t, newer, ts, "newer")
+	writeDeadLetterFile(t, older, ts, "older")
+	writeDeadLetterFile(t, other, ts, "other")
+
+	files, err := deadLetterFiles([]string{dir, other})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{older, newer, other}, files)
+
+	_, err = deadLetterFiles([]string{filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+}
+
+func TestReadEventsSkipsInvalidLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dead-letter.ndjson")
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	writeDeadLetterFile(t, path, ts, `{"mykey":"first"}`, `{"mykey":"second"}`)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("not json\n\n{\"timestamp\":1}\n")
+	f.Close()
+
+	events, skipped, err := readEvents(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, skipped)
+	assert.Len(t, events, 2)
+	assert.Equal(t, `{"mykey":"first"}`, *events[0].Message)
+	assert.Equal(t, aws.TimeUnixMilli(ts), *events[0].Timestamp)
+	assert.Equal(t, aws.TimeUnixMilli(ts.Add(time.Second)), *events[1].Timestamp)
+}
+
+func TestReadEventsSkipsTooLongLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(size int) { maxLineSize = size }(maxLineSize)
+	// Longer than the buffer of bufio.Reader
+	maxLineSize = 8 * 1024
+
+	path := filepath.Join(dir, "dead-letter.ndjson")
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	long := `"` + strings.Repeat("a", 6*1024) + `"`
+	writeDeadLetterFile(t, path, ts, long, strings.Repeat(long, 2), `{"mykey":"last"}`)
+
+	events, skipped, err := readEvents(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, skipped)
+	assert.Len(t, events, 2)
+	assert.Equal(t, long, *events[0].Message)
+	assert.Equal(t, `{"mykey":"last"}`, *events[1].Message)
+}
+
+func newTestReplayer(api cwlogs.API, out *bytes.Buffer) *replayer {
+	retry, _ := cwlogs.GetRetryConfig("", "", "", "")
+	return &replayer{
+		client:    cwlogs.NewClient(api, nil, true, retry, "", nil),
+		token:     cwlogs.UpdateToken{LogGroup: "replaygroup", LogStream: "replaystream"},
+		batchSize: 2,
+		out:       out,
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	first := filepath.Join(dir, "dead-letter-1.ndjson")
+	second := filepath.Join(dir, "dead-letter-2.ndjson")
+	writeDeadLetterFile(t, first, ts.Add(time.Minute), "c", "d", "e")
+	writeDeadLetterFile(t, second, ts, "a", "b")
+
+	api := &fakeAPI{}
+	var out bytes.Buffer
+	r := newTestReplayer(api, &out)
+	r.interval = 20 * time.Millisecond
+	start := time.Now()
+	stats, err := r.replay([]string{first, second})
+	assert.NoError(t, err)
+
+	// The events are sorted across the files.
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, api.batches)
+	assert.Equal(t, []string{"replaygroup", "replaygroup/replaystream"}, api.created)
+	assert.Equal(t, 5, stats.sent)
+	assert.Equal(t, 3, stats.batches)
+	// The calls are limited by the rate.
+	assert.True(t, time.Since(start) >= 2*r.interval)
+	assert.Contains(t, out.String(), "Replayed 5/5 events (100.0%) in 3 batches to replaygroup/replaystream, 0 dropped")
+}
+
+func TestReplayDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	path := filepath.Join(dir, "dead-letter.ndjson")
+	writeDeadLetterFile(t, path, ts, "a", "b", "c")
+
+	var out bytes.Buffer
+	r := &replayer{
+		token:     cwlogs.UpdateToken{LogGroup: "replaygroup", LogStream: "replaystream"},
+		batchSize: 2,
+		dryRun:    true,
+		out:       &out,
+	}
+	stats, err := r.replay([]string{path})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.events)
+	assert.Equal(t, 0, stats.sent)
+	assert.Contains(t, out.String(), "Batch 1: 2 events from 2019-03-10T10:11:12Z to 2019-03-10T10:11:13Z")
+	assert.Contains(t, out.String(), "Batch 2: 1 events from 2019-03-10T10:11:14Z to 2019-03-10T10:11:14Z")
+	assert.Contains(t, out.String(), "Dry run: 3 events in 2 batches would be sent to replaygroup/replaystream")
+}
+
+func TestReplayStopsAtUndeliverableBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	path := filepath.Join(dir, "dead-letter.ndjson")
+	writeDeadLetterFile(t, path, ts, "a", "b", "c", "d", "e")
+
+	api := &fakeAPI{putErrors: map[int]error{2: errors.New("connection reset")}}
+	var out bytes.Buffer
+	stats, err := newTestReplayer(api, &out).replay([]string{path})
+	assert.Error(t, err)
+	assert.Equal(t, 2, stats.sent)
+	assert.Equal(t, [][]string{{"a", "b"}}, api.batches)
+	assert.Contains(t, out.String(), "Replayed 2/5 events (40.0%) in 1 batches")
+}
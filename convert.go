@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/json-iterator/go"
+)
+
+// toJSONValue converts a value decoded from msgpack to a value which
+// jsoniter always encodes: maps get string keys, byte slices and strings
+// become valid UTF-8 strings, timestamps become RFC 3339 strings, and
+// NaN and infinities become strings. Types which the decoder is not
+// expected to produce are formatted with fmt.
+func toJSONValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return t
+	case float32:
+		return jsonFloat(float64(t), t)
+	case float64:
+		return jsonFloat(t, t)
+	case string:
+		return validUTF8(t)
+	case []byte:
+		// prevent encoding to base64
+		return validUTF8(string(t))
+	case []interface{}:
+		values := make([]interface{}, len(t))
+		for i, e := range t {
+			values[i] = toJSONValue(e)
+		}
+		return values
+	case map[interface{}]interface{}:
+		return toJSONMap(t)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[validUTF8(k)] = toJSONValue(e)
+		}
+		return m
+	case output.FLBTime:
+		return t.Time.UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	default:
+		return validUTF8(fmt.Sprint(t))
+	}
+}
+
+// toJSONMap converts a map decoded from msgpack with toJSONValue.
+func toJSONMap(m map[interface{}]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		converted[jsonKey(k)] = toJSONValue(v)
+	}
+	return converted
+}
+
+// jsonKey returns the key of an object for a map key, which can be of any
+// msgpack type. Keys other than strings are encoded to JSON, e.g. 1 and
+// true become "1" and "true".
+func jsonKey(k interface{}) string {
+	switch t := toJSONValue(k).(type) {
+	case string:
+		return t
+	default:
+		js, err := jsoniter.MarshalToString(t)
+		if err != nil {
+			return validUTF8(fmt.Sprint(t))
+		}
+		return js
+	}
+}
+
+func jsonFloat(f float64, v interface{}) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return v
+}
+
+// validUTF8 replaces the invalid UTF-8 sequences in s with U+FFFD, which
+// CloudWatch Logs requires.
+func validUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(utf8.RuneError)
+		} else {
+			b.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestCreateJSONConvertsNestedValues(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	record := map[interface{}]interface{}{
+		"log":     []byte("line"),
+		"k\xff":   "invalid key",
+		1:         "int key",
+		true:      "bool key",
+		nil:       "nil key",
+		"invalid": "a\xffb",
+		"nan":     math.NaN(),
+		"inf":     float32(math.Inf(-1)),
+		"time":    output.FLBTime{Time: ts},
+		"binary":  []byte{0xc3, 0x28},
+		"int":     int64(-1),
+		"uint":    uint64(math.MaxUint64),
+		"ext":     codec.RawExt{Tag: 5, Data: []byte{1, 2}},
+		"array":   []interface{}{[]byte("a"), map[interface{}]interface{}{"k": []byte("v")}},
+		"kubernetes": map[interface{}]interface{}{
+			"labels": map[interface{}]interface{}{
+				2.5: []interface{}{nil, false},
+			},
+		},
+	}
+
+	js, err := createJSON(record)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(js), &parsed); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "line", parsed["log"])
+	assert.Equal(t, "invalid key", parsed["k�"])
+	assert.Equal(t, "int key", parsed["1"])
+	assert.Equal(t, "bool key", parsed["true"])
+	assert.Equal(t, "nil key", parsed["null"])
+	assert.Equal(t, "a�b", parsed["invalid"])
+	assert.Equal(t, "NaN", parsed["nan"])
+	assert.Equal(t, "-Inf", parsed["inf"])
+	assert.Equal(t, "2019-03-10T10:11:12Z", parsed["time"])
+	assert.Equal(t, "�(", parsed["binary"])
+	assert.Equal(t, float64(-1), parsed["int"])
+	assert.Contains(t, js, `"uint":18446744073709551615`)
+	assert.IsType(t, "", parsed["ext"])
+	assert.Equal(t, []interface{}{"a", map[string]interface{}{"k": "v"}}, parsed["array"])
+	assert.Equal(t, map[string]interface{}{
+		"labels": map[string]interface{}{"2.5": []interface{}{nil, false}},
+	}, parsed["kubernetes"])
+}
+
+func TestValidUTF8(t *testing.T) {
+	assert.Equal(t, "héllo", validUTF8("héllo"))
+	assert.Equal(t, "��ok", validUTF8("\xff\xfeok"))
+	assert.Equal(t, "a�", validUTF8("a\xe3\x81"[:2]))
+}
+
+// randomValue returns a value of any msgpack type, including invalid UTF-8
+// strings, NaN and nested maps with keys which are not strings.
+func randomValue(r *rand.Rand, depth int) interface{} {
+	n := 10
+	if depth > 4 {
+		n = 8
+	}
+	switch r.Intn(n) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return r.Int63() - r.Int63()
+	case 3:
+		return r.Uint64()
+	case 4:
+		return []float64{math.NaN(), math.Inf(1), math.Inf(-1), r.NormFloat64()}[r.Intn(4)]
+	case 5:
+		return float32(r.NormFloat64())
+	case 6:
+		return string(randomBytes(r))
+	case 7:
+		return randomBytes(r)
+	case 8:
+		values := make([]interface{}, r.Intn(4))
+		for i := range values {
+			values[i] = randomValue(r, depth+1)
+		}
+		return values
+	default:
+		return randomMap(r, depth+1)
+	}
+}
+
+func randomMap(r *rand.Rand, depth int) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{})
+	for i := r.Intn(5); i > 0; i-- {
+		var key interface{}
+		switch r.Intn(5) {
+		case 0:
+			key = r.Int63()
+		case 1:
+			key = r.Intn(2) == 0
+		case 2:
+			key = nil
+		case 3:
+			key = r.Float64()
+		default:
+			key = string(randomBytes(r))
+		}
+		m[key] = randomValue(r, depth)
+	}
+	return m
+}
+
+func randomBytes(r *rand.Rand) []byte {
+	b := make([]byte, r.Intn(8))
+	r.Read(b)
+	return b
+}
+
+func assertValidJSONMessage(t *testing.T, record map[interface{}]interface{}) {
+	js, err := createJSON(record)
+	if err != nil {
+		t.Fatalf("failed to create JSON of %#v: %v", record, err)
+	}
+	if !utf8.ValidString(js) {
+		t.Fatalf("invalid UTF-8 in JSON of %#v: %q", record, js)
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(js), &parsed); err != nil {
+		t.Fatalf("invalid JSON of %#v: %q", record, js)
+	}
+}
+
+// TestCreateJSONFuzz sends random msgpack records through the decoder of
+// fluent-bit-go, and corrupts them at random, so that createJSON is called
+// with every type the decoder produces.
+func TestCreateJSONFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	handle := new(codec.MsgpackHandle)
+
+	for i := 0; i < 2000; i++ {
+		var data []byte
+		entry := []interface{}{uint64(r.Uint32()), randomMap(r, 0)}
+		if err := codec.NewEncoderBytes(&data, handle).Encode(entry); err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+
+		dec := output.NewDecoder(unsafe.Pointer(&data[0]), len(data))
+		ret, _, record := output.GetRecord(dec)
+		assert.Equal(t, 0, ret)
+		assertValidJSONMessage(t, record)
+
+		// Flip some bytes, and convert any map which is still decoded.
+		for j := r.Intn(3) + 1; j > 0; j-- {
+			data[r.Intn(len(data))] = byte(r.Intn(256))
+		}
+		var m interface{}
+		if err := codec.NewDecoderBytes(data, handle).Decode(&m); err != nil {
+			continue
+		}
+		if entry, ok := m.([]interface{}); ok && len(entry) == 2 {
+			m = entry[1]
+		}
+		if record, ok := m.(map[interface{}]interface{}); ok {
+			assertValidJSONMessage(t, record)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cwlogs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	defaultRoleSessionName = "fluent-bit-go-cloudwatch-logs"
+	// The assumed role credentials are refreshed this long before they expire.
+	assumeRoleExpiryWindow = time.Minute
+)
+
+type AssumeRoleConf struct {
+	roleARN         string
+	externalID      string
+	roleSessionName string
+	endpoints       *EndpointConf
+}
+
+func GetAssumeRoleConfig(roleARN, externalID, roleSessionName string, endpoints *EndpointConf) *AssumeRoleConf {
+	if roleARN == "" {
+		return nil
+	}
+
+	if roleSessionName == "" {
+		roleSessionName = defaultRoleSessionName
+	}
+
+	return &AssumeRoleConf{
+		roleARN:         roleARN,
+		externalID:      externalID,
+		roleSessionName: roleSessionName,
+		endpoints:       endpoints,
+	}
+}
+
+// Credentials returns the credentials of the role which is assumed with
+// the base credentials. The role is assumed on the first use, and assumed
+// again before the credentials expire.
+func (c *AssumeRoleConf) Credentials(base *credentials.Credentials, region string) *credentials.Credentials {
+	return credentials.NewCredentials(c.provider(base, region))
+}
+
+func (c *AssumeRoleConf) provider(base *credentials.Credentials, region string) *stscreds.AssumeRoleProvider {
+	config := c.endpoints.Config(ServiceSTS, region)
+	config.Credentials = base
+
+	p := &stscreds.AssumeRoleProvider{
+		Client:          sts.New(session.New(config)),
+		RoleARN:         c.roleARN,
+		RoleSessionName: c.roleSessionName,
+		Duration:        stscreds.DefaultDuration,
+		ExpiryWindow:    assumeRoleExpiryWindow,
+	}
+	if c.externalID != "" {
+		p.ExternalID = aws.String(c.externalID)
+	}
+	return p
+}
@@ -0,0 +1,83 @@
+package cwlogs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMEDAKID</AccessKeyId>
+      <SecretAccessKey>ASSUMEDSECRET</SecretAccessKey>
+      <SessionToken>ASSUMEDTOKEN</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/logging/fluent-bit</Arn>
+      <AssumedRoleId>AROA:fluent-bit</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata>
+    <RequestId>c6104cbe-af31-11e0-8154-cbc7ccf896c7</RequestId>
+  </ResponseMetadata>
+</AssumeRoleResponse>`
+
+func TestGetAssumeRoleConfig(t *testing.T) {
+	assert.Nil(t, GetAssumeRoleConfig("", "", "", nil))
+
+	conf := GetAssumeRoleConfig("arn:aws:iam::123456789012:role/logging", "", "", nil)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/logging", conf.roleARN)
+	assert.Equal(t, defaultRoleSessionName, conf.roleSessionName)
+}
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	var forms []url.Values
+	var authorizations []string
+	expiration := time.Now().Add(30 * time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		fmt.Fprintf(w, assumeRoleResponse, expiration.UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	conf := GetAssumeRoleConfig("arn:aws:iam::123456789012:role/logging", "exampleexternalid", "fluent-bit", &EndpointConf{stsEndpoint: server.URL})
+	base := credentials.NewStaticCredentials("AKID", "SECRET", "")
+	creds := conf.Credentials(base, "us-east-1")
+
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "ASSUMEDAKID", value.AccessKeyID)
+	assert.Equal(t, "ASSUMEDSECRET", value.SecretAccessKey)
+	assert.Equal(t, "ASSUMEDTOKEN", value.SessionToken)
+
+	assert.Len(t, forms, 1)
+	assert.Equal(t, "AssumeRole", forms[0].Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/logging", forms[0].Get("RoleArn"))
+	assert.Equal(t, "exampleexternalid", forms[0].Get("ExternalId"))
+	assert.Equal(t, "fluent-bit", forms[0].Get("RoleSessionName"))
+	// The request is signed with the base credentials.
+	assert.Contains(t, authorizations[0], "Credential=AKID/")
+
+	// The credentials expire within the expiry window, so that the role
+	// is assumed again.
+	expiration = time.Now().Add(time.Hour)
+	assert.True(t, creds.IsExpired())
+	_, err = creds.Get()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Len(t, forms, 2)
+	assert.False(t, creds.IsExpired())
+}
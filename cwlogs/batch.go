@@ -0,0 +1,103 @@
+package cwlogs
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// PutLogEvents limits.
+// See https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html
+const (
+	MaxBatchEvents    = 10000
+	maxBatchSize      = 1048576
+	EventSizeOverhead = 26
+	maxBatchSpan      = 24 * time.Hour
+)
+
+type batchDigest [sha1.Size]byte
+
+// sortEvents sorts events in chronological order. PutLogEvents rejects
+// batches which are not sorted.
+func sortEvents(events []*cloudwatchlogs.InputLogEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return *events[i].Timestamp < *events[j].Timestamp
+	})
+}
+
+func eventSize(event *cloudwatchlogs.InputLogEvent) int {
+	return len(*event.Message) + EventSizeOverhead
+}
+
+// SplitBatches sorts events and splits them into batches which satisfy
+// the number of events, the total size and the time span limits of
+// a PutLogEvents call.
+func SplitBatches(events []*cloudwatchlogs.InputLogEvent) [][]*cloudwatchlogs.InputLogEvent {
+	var batches [][]*cloudwatchlogs.InputLogEvent
+	if len(events) == 0 {
+		return batches
+	}
+
+	sortEvents(events)
+
+	start := 0
+	size := 0
+	for i, event := range events {
+		span := time.Duration(*event.Timestamp-*events[start].Timestamp) * time.Millisecond
+		if i > start && (i-start >= MaxBatchEvents || size+eventSize(event) > maxBatchSize || span >= maxBatchSpan) {
+			batches = append(batches, events[start:i])
+			start = i
+			size = 0
+		}
+		size += eventSize(event)
+	}
+	batches = append(batches, events[start:])
+
+	return batches
+}
+
+// digestBatch identifies a batch which is sent to a logStream. It is used
+// to skip the batches which were already delivered when fluent-bit retries
+// the same chunk.
+func digestBatch(token UpdateToken, batch []*cloudwatchlogs.InputLogEvent) batchDigest {
+	h := sha1.New()
+	buf := make([]byte, 8)
+	writeString := func(s string) {
+		binary.BigEndian.PutUint64(buf, uint64(len(s)))
+		h.Write(buf)
+		h.Write([]byte(s))
+	}
+
+	writeString(token.LogGroup)
+	writeString(token.LogStream)
+	for _, event := range batch {
+		binary.BigEndian.PutUint64(buf, uint64(*event.Timestamp))
+		h.Write(buf)
+		writeString(*event.Message)
+	}
+
+	var digest batchDigest
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// ChunkID identifies a chunk of Fluent Bit, which is flushed again with
+// the same events when it is retried. The zero ChunkID is not tracked.
+type ChunkID [sha1.Size]byte
+
+// DigestChunk returns the ChunkID of the events which a flush sends to
+// each (logGroup, logStream) pair in the order of tokens.
+func DigestChunk(tokens []UpdateToken, events map[UpdateToken][]*cloudwatchlogs.InputLogEvent) ChunkID {
+	h := sha1.New()
+	for _, token := range tokens {
+		digest := digestBatch(token, events[token])
+		h.Write(digest[:])
+	}
+
+	var id ChunkID
+	copy(id[:], h.Sum(nil))
+	return id
+}
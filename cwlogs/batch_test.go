@@ -0,0 +1,92 @@
+package cwlogs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEvent(message string, ts time.Time) *cloudwatchlogs.InputLogEvent {
+	return &cloudwatchlogs.InputLogEvent{
+		Message:   aws.String(message),
+		Timestamp: aws.Int64(aws.TimeUnixMilli(ts)),
+	}
+}
+
+func TestSplitBatchesSortsEvents(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	events := []*cloudwatchlogs.InputLogEvent{
+		newTestEvent("third", ts.Add(2*time.Second)),
+		newTestEvent("first", ts),
+		newTestEvent("second", ts.Add(time.Second)),
+	}
+
+	batches := SplitBatches(events)
+	assert.Len(t, batches, 1)
+	assert.Equal(t, "first", *batches[0][0].Message)
+	assert.Equal(t, "second", *batches[0][1].Message)
+	assert.Equal(t, "third", *batches[0][2].Message)
+}
+
+func TestSplitBatchesByCount(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	var events []*cloudwatchlogs.InputLogEvent
+	for i := 0; i < MaxBatchEvents*2+1; i++ {
+		events = append(events, newTestEvent("message", ts))
+	}
+
+	batches := SplitBatches(events)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[0], MaxBatchEvents)
+	assert.Len(t, batches[1], MaxBatchEvents)
+	assert.Len(t, batches[2], 1)
+}
+
+func TestSplitBatchesBySize(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	// Four events fill a batch exactly when the per-event overhead is counted.
+	message := strings.Repeat("a", maxBatchSize/4-EventSizeOverhead)
+	var events []*cloudwatchlogs.InputLogEvent
+	for i := 0; i < 5; i++ {
+		events = append(events, newTestEvent(message, ts))
+	}
+
+	batches := SplitBatches(events)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], 4)
+	assert.Len(t, batches[1], 1)
+}
+
+func TestSplitBatchesBySpan(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	events := []*cloudwatchlogs.InputLogEvent{
+		newTestEvent("first", ts),
+		newTestEvent("second", ts.Add(23*time.Hour)),
+		newTestEvent("third", ts.Add(24*time.Hour)),
+		newTestEvent("fourth", ts.Add(25*time.Hour)),
+	}
+
+	batches := SplitBatches(events)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 2)
+	assert.Equal(t, "third", *batches[1][0].Message)
+}
+
+func TestSplitBatchesEmpty(t *testing.T) {
+	assert.Empty(t, SplitBatches(nil))
+}
+
+func TestDigestBatch(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	batch := []*cloudwatchlogs.InputLogEvent{newTestEvent("message", ts)}
+
+	assert.Equal(t, digestBatch(UpdateToken{"group", "stream"}, batch), digestBatch(UpdateToken{"group", "stream"}, batch))
+	assert.NotEqual(t, digestBatch(UpdateToken{"group", "stream"}, batch), digestBatch(UpdateToken{"group", "other"}, batch))
+	assert.NotEqual(t, digestBatch(UpdateToken{"group", "stream"}, batch),
+		digestBatch(UpdateToken{"group", "stream"}, []*cloudwatchlogs.InputLogEvent{newTestEvent("message", ts.Add(time.Millisecond))}))
+}
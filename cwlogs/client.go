@@ -0,0 +1,495 @@
+// Package cwlogs sends events to CloudWatch Logs with the credentials,
+// endpoints and retries configured by the plugin options. It is shared by
+// the Fluent Bit plugin and cloudwatch-logs-replay.
+package cwlogs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// API is the part of the CloudWatch Logs API which Client calls.
+type API interface {
+	Put(client *cloudwatchlogs.CloudWatchLogs, logEvents []*cloudwatchlogs.InputLogEvent, logGroupName, logStreamName, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error)
+	CheckLogGroupsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) bool
+	CheckLogStreamsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) (bool, string)
+	CreateLogGroup(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) error
+	CreateLogStream(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) error
+}
+
+// SDKAPI implements API with the CloudWatch Logs client of the SDK.
+type SDKAPI struct{}
+
+func (a *SDKAPI) Put(client *cloudwatchlogs.CloudWatchLogs, logEvents []*cloudwatchlogs.InputLogEvent, logGroupName, logStreamName, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	params := &cloudwatchlogs.PutLogEventsInput{
+		LogEvents:     logEvents,
+		LogGroupName:  aws.String(logGroupName),  // Mandatory
+		LogStreamName: aws.String(logStreamName), // Mandatory
+	}
+	if sequenceToken != "" {
+		params.SequenceToken = aws.String(sequenceToken)
+	}
+	resp, err := client.PutLogEvents(params)
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			// Get error details
+			fmt.Println("Error:", awsErr.Code(), awsErr.Message())
+
+			// Prints out full error message, including original error if there was one.
+			fmt.Println("Error:", awsErr.Error())
+			return nil, err
+		} else if err != nil {
+			// A non-service error occurred.
+			// A service error occurred.
+			fmt.Printf("Fatal: %v\n", err)
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (a *SDKAPI) CheckLogGroupsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) bool {
+	params := &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(logGroupName), // Required
+	}
+	resp, err := client.DescribeLogGroups(params)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return false
+	}
+
+	logGroups := resp.LogGroups
+
+	for _, logGroup := range logGroups {
+		if logGroupName == *logGroup.LogGroupName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *SDKAPI) CheckLogStreamsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) (bool, string) {
+	params := &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(logGroupName),
+		LogStreamNamePrefix: aws.String(logStreamName), // Required
+	}
+	resp, err := client.DescribeLogStreams(params)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return false, ""
+	}
+
+	logStreams := resp.LogStreams
+
+	for _, logStream := range logStreams {
+		if logStreamName == *logStream.LogStreamName {
+			// A logStream which has no events yet does not have UploadSequenceToken.
+			return true, aws.StringValue(logStream.UploadSequenceToken)
+		}
+	}
+
+	return false, ""
+}
+
+func (a *SDKAPI) CreateLogGroup(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) error {
+	params := &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(logGroupName), // Required
+	}
+	_, err := client.CreateLogGroup(params)
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			// Get error details
+			fmt.Println("Error:", awsErr.Code(), awsErr.Message())
+
+			// Prints out full error message, including original error if there was one.
+			fmt.Println("Error:", awsErr.Error())
+			return err
+		} else if err != nil {
+			// A non-service error occurred.
+			// A service error occurred.
+			fmt.Printf("Fatal: %v\n", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *SDKAPI) CreateLogStream(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) error {
+	params := &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(logGroupName),  // Required
+		LogStreamName: aws.String(logStreamName), // Required
+	}
+	_, err := client.CreateLogStream(params)
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			// Get error details
+			fmt.Println("Error:", awsErr.Code(), awsErr.Message())
+
+			// Prints out full error message, including original error if there was one.
+			fmt.Println("Error:", awsErr.Error())
+			return err
+		} else if err != nil {
+			// A non-service error occurred.
+			// A service error occurred.
+			fmt.Printf("Fatal: %v\n", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateToken identifies a logStream, which has its own sequence token.
+type UpdateToken struct {
+	LogGroup  string
+	LogStream string
+}
+
+// Client sends events to CloudWatch Logs. It keeps the logGroups and the
+// logStreams which are known to exist, and the sequence tokens of the
+// logStreams.
+//
+// Client can be used by multiple goroutines as long as each logStream is
+// sent from one goroutine at a time, which keeps the sequence token chain
+// of the logStream in order.
+type Client struct {
+	api              API
+	cloudwatchLogs   *cloudwatchlogs.CloudWatchLogs
+	autoCreateStream bool
+	retry            *RetryConf
+	rejectedEvents   string
+	deadLetter       *DeadLetterQueue
+
+	// mu guards the fields below.
+	mu             sync.Mutex
+	logGroups      map[string]bool
+	sequenceTokens map[UpdateToken]string
+	// delivered holds the batches of each chunk which were accepted
+	// while the chunk has not been completed yet.
+	delivered map[ChunkID]*deliveredBatches
+	// deliveredSeq orders the entries of delivered to evict the oldest.
+	deliveredSeq uint64
+	// droppedBatches counts the batches dropped by RetryPolicy.
+	droppedBatches uint64
+}
+
+// NewClient creates a Client. rejectedEvents is the file to append the
+// events which CloudWatch Logs rejected, and deadLetter may be nil.
+func NewClient(api API, cloudwatchLogs *cloudwatchlogs.CloudWatchLogs, autoCreateStream bool, retry *RetryConf, rejectedEvents string, deadLetter *DeadLetterQueue) *Client {
+	return &Client{
+		api:              api,
+		cloudwatchLogs:   cloudwatchLogs,
+		autoCreateStream: autoCreateStream,
+		retry:            retry,
+		rejectedEvents:   rejectedEvents,
+		deadLetter:       deadLetter,
+		logGroups:        make(map[string]bool),
+		sequenceTokens:   make(map[UpdateToken]string),
+		delivered:        make(map[ChunkID]*deliveredBatches),
+	}
+}
+
+// SequenceToken returns the sequence token of the logStream which is
+// used in the next PutLogEvents call.
+func (c *Client) SequenceToken(token UpdateToken) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sequenceTokens[token]
+}
+
+func (c *Client) lookupSequenceToken(token UpdateToken) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sequenceToken, ok := c.sequenceTokens[token]
+	return sequenceToken, ok
+}
+
+func (c *Client) setSequenceToken(token UpdateToken, sequenceToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sequenceTokens[token] = sequenceToken
+}
+
+func (c *Client) forgetSequenceToken(token UpdateToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sequenceTokens, token)
+}
+
+// DroppedBatches returns the number of the batches dropped so far.
+func (c *Client) DroppedBatches() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.droppedBatches
+}
+
+// maxDeliveredChunks bounds the chunks whose delivered batches are kept,
+// since Fluent Bit may give up retrying a chunk without telling the plugin.
+const maxDeliveredChunks = 256
+
+type deliveredBatches struct {
+	seq     uint64
+	batches map[batchDigest]bool
+}
+
+// ClearDelivered forgets the delivered batches of the chunk, which is
+// called when the whole chunk has been delivered or dropped.
+func (c *Client) ClearDelivered(chunk ChunkID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.delivered, chunk)
+}
+
+func (c *Client) isDelivered(chunk ChunkID, digest batchDigest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.delivered[chunk]
+	return ok && d.batches[digest]
+}
+
+func (c *Client) setDelivered(chunk ChunkID, digest batchDigest) {
+	if chunk == (ChunkID{}) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.delivered[chunk]
+	if !ok {
+		if len(c.delivered) >= maxDeliveredChunks {
+			c.evictOldestDelivered()
+		}
+		c.deliveredSeq++
+		d = &deliveredBatches{seq: c.deliveredSeq, batches: make(map[batchDigest]bool)}
+		c.delivered[chunk] = d
+	}
+	d.batches[digest] = true
+}
+
+// UndeliveredEvents returns the events of the batches of the chunk which
+// were neither delivered nor dropped in the previous attempts.
+func (c *Client) UndeliveredEvents(chunk ChunkID, token UpdateToken, events []*cloudwatchlogs.InputLogEvent) []*cloudwatchlogs.InputLogEvent {
+	var undelivered []*cloudwatchlogs.InputLogEvent
+	for _, batch := range SplitBatches(events) {
+		if !c.isDelivered(chunk, digestBatch(token, batch)) {
+			undelivered = append(undelivered, batch...)
+		}
+	}
+	return undelivered
+}
+
+func (c *Client) evictOldestDelivered() {
+	var oldest ChunkID
+	var oldestSeq uint64
+	for chunk, d := range c.delivered {
+		if oldestSeq == 0 || d.seq < oldestSeq {
+			oldest, oldestSeq = chunk, d.seq
+		}
+	}
+	delete(c.delivered, oldest)
+}
+
+func (c *Client) knownLogGroup(logGroupName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logGroups[logGroupName]
+}
+
+func (c *Client) setKnownLogGroup(logGroupName string, known bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if known {
+		c.logGroups[logGroupName] = true
+	} else {
+		delete(c.logGroups, logGroupName)
+	}
+}
+
+// Close closes the dead letter queue.
+func (c *Client) Close() {
+	if c.deadLetter != nil {
+		c.deadLetter.Close()
+	}
+}
+
+// WriteDeadLetters writes events which are given up without being sent to
+// DeadLetterDir.
+func (c *Client) WriteDeadLetters(token UpdateToken, events []*cloudwatchlogs.InputLogEvent, cause error) error {
+	if c.deadLetter == nil {
+		return fmt.Errorf("DeadLetterDir is not specified")
+	}
+	return c.deadLetter.write(token, events, cause)
+}
+
+// PrepareLogGroup creates the logGroup which is not known yet when it
+// does not exist.
+func (c *Client) PrepareLogGroup(logGroupName string) {
+	if !c.autoCreateStream || c.knownLogGroup(logGroupName) {
+		return
+	}
+
+	if !c.api.CheckLogGroupsExistence(c.cloudwatchLogs, logGroupName) {
+		err := c.api.CreateLogGroup(c.cloudwatchLogs, logGroupName)
+		if err != nil {
+			fmt.Printf("Failed to create logGroup. error: %v\n", err)
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+				// Try it again in the next flush.
+				return
+			}
+		}
+	}
+	c.setKnownLogGroup(logGroupName, true)
+}
+
+// PrepareLogStream looks up the sequence token of a logStream which is
+// not known yet, and creates the logStream when it does not exist.
+// Known logStreams are kept in sequenceTokens.
+func (c *Client) PrepareLogStream(logGroupName, logStreamName string) {
+	token := UpdateToken{logGroupName, logStreamName}
+	if _, ok := c.lookupSequenceToken(token); ok {
+		return
+	}
+
+	if !c.autoCreateStream {
+		c.setSequenceToken(token, "")
+		return
+	}
+
+	if doesExist, nextToken := c.api.CheckLogStreamsExistence(c.cloudwatchLogs, logGroupName, logStreamName); doesExist {
+		c.setSequenceToken(token, nextToken)
+		return
+	}
+
+	err := c.api.CreateLogStream(c.cloudwatchLogs, logGroupName, logStreamName)
+	if err != nil {
+		fmt.Printf("Failed to create logStream. error: %v\n", err)
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+			// Try it again in the next flush.
+			return
+		}
+	}
+	c.setSequenceToken(token, "")
+}
+
+// PutLogEvents sends events of the chunk in batches which respect the
+// PutLogEvents limits, chaining the sequence token between them. Batches
+// which were delivered in a previous attempt of the same chunk are
+// skipped, so that only the failed batch and the following ones are sent
+// again on retry. With the zero ChunkID, every batch is sent. It reports
+// whether a batch was dropped by RetryPolicy.
+//
+// The dropped batches are written to DeadLetterDir. With DeadLetterDir,
+// the batches which exhausted the retries with backoff are also written
+// there and dropped instead of being retried by Fluent Bit.
+func (c *Client) PutLogEvents(chunk ChunkID, token UpdateToken, events []*cloudwatchlogs.InputLogEvent) (bool, error) {
+	dropped := false
+	deadLetter := c.deadLetter
+	for _, batch := range SplitBatches(events) {
+		digest := digestBatch(token, batch)
+		if c.isDelivered(chunk, digest) {
+			continue
+		}
+
+		if err := c.putBatch(token, batch); err != nil {
+			action := c.retry.action(err)
+			if action != retryDrop && !(action == retryBackoff && deadLetter != nil) {
+				return dropped, err
+			}
+			if deadLetter != nil {
+				if dlqErr := deadLetter.write(token, batch, err); dlqErr != nil {
+					fmt.Printf("Failed to write a batch to DeadLetterDir. error: %v\n", dlqErr)
+					if action != retryDrop {
+						return dropped, err
+					}
+				}
+			}
+			c.mu.Lock()
+			c.droppedBatches++
+			droppedBatches := c.droppedBatches
+			c.mu.Unlock()
+			fmt.Printf("Dropped a batch of %d events to %s/%s. error: %v (%d batches dropped so far)\n",
+				len(batch), token.LogGroup, token.LogStream, err, droppedBatches)
+			dropped = true
+		}
+		c.setDelivered(chunk, digest)
+	}
+
+	return dropped, nil
+}
+
+// putBatch sends a batch, and recovers from a stale sequence token by
+// retrying with the expected one. A batch which was already accepted is
+// treated as delivered. The errors which are classified as retryBackoff
+// are retried with exponential backoff.
+func (c *Client) putBatch(token UpdateToken, batch []*cloudwatchlogs.InputLogEvent) error {
+	backoffs := 0
+	for attempt := 1; ; attempt++ {
+		sequenceToken, _ := c.lookupSequenceToken(token)
+		resp, err := c.api.Put(c.cloudwatchLogs, batch, token.LogGroup, token.LogStream, sequenceToken)
+		if err == nil {
+			c.setSequenceToken(token, nextSequenceToken(resp))
+			if resp != nil && resp.RejectedLogEventsInfo != nil {
+				info := resp.RejectedLogEventsInfo
+				handleRejectedEvents(c.rejectedEvents, rejectedEvents(token, batch, info))
+			}
+			return nil
+		}
+
+		awsErr, ok := err.(awserr.Error)
+		if !ok {
+			return err
+		}
+		switch awsErr.Code() {
+		case cloudwatchlogs.ErrCodeDataAlreadyAcceptedException:
+			if nextToken, found := expectedSequenceToken(awsErr.Message()); found {
+				c.setSequenceToken(token, nextToken)
+			} else {
+				c.forgetSequenceToken(token)
+			}
+			return nil
+		case cloudwatchlogs.ErrCodeInvalidSequenceTokenException:
+			if attempt >= maxSequenceTokenAttempts {
+				return err
+			}
+			if nextToken, found := expectedSequenceToken(awsErr.Message()); found {
+				c.setSequenceToken(token, nextToken)
+			} else if _, nextToken := c.api.CheckLogStreamsExistence(c.cloudwatchLogs, token.LogGroup, token.LogStream); nextToken != "" {
+				c.setSequenceToken(token, nextToken)
+			} else {
+				return err
+			}
+			fmt.Printf("Retrying with the expected sequence token for %s/%s\n", token.LogGroup, token.LogStream)
+		case cloudwatchlogs.ErrCodeResourceNotFoundException:
+			// Prepare the logGroup and the logStream again in the next flush.
+			c.setKnownLogGroup(token.LogGroup, false)
+			c.forgetSequenceToken(token)
+			return err
+		default:
+			retry := c.retry
+			if retry.action(err) != retryBackoff || backoffs >= retry.maxAttempts {
+				return err
+			}
+			backoffs++
+			delay := retry.Backoff(backoffs)
+			fmt.Printf("Retrying %s/%s in %v (%d/%d). error: %s\n", token.LogGroup, token.LogStream, delay, backoffs, retry.maxAttempts, awsErr.Code())
+			sleep(delay)
+		}
+	}
+}
+
+func nextSequenceToken(response *cloudwatchlogs.PutLogEventsOutput) string {
+	if response != nil {
+		return aws.StringValue(response.NextSequenceToken)
+	} else {
+		return ""
+	}
+}
@@ -0,0 +1,186 @@
+package cwlogs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI keeps the events put into each logStream. putErrors holds the
+// error of the nth Put call.
+type fakeAPI struct {
+	logGroups  map[string]bool
+	logStreams map[UpdateToken][]string
+	putCalls   int
+	putErrors  map[int]error
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		logGroups:  make(map[string]bool),
+		logStreams: make(map[UpdateToken][]string),
+	}
+}
+
+func (f *fakeAPI) Put(client *cloudwatchlogs.CloudWatchLogs, logEvents []*cloudwatchlogs.InputLogEvent, logGroupName, logStreamName, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.putCalls++
+	if err, ok := f.putErrors[f.putCalls]; ok {
+		return nil, err
+	}
+	token := UpdateToken{logGroupName, logStreamName}
+	for _, event := range logEvents {
+		f.logStreams[token] = append(f.logStreams[token], *event.Message)
+	}
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("nexttoken")}, nil
+}
+
+func (f *fakeAPI) CheckLogGroupsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) bool {
+	return f.logGroups[logGroupName]
+}
+
+func (f *fakeAPI) CheckLogStreamsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) (bool, string) {
+	_, ok := f.logStreams[UpdateToken{logGroupName, logStreamName}]
+	return ok, ""
+}
+
+func (f *fakeAPI) CreateLogGroup(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) error {
+	f.logGroups[logGroupName] = true
+	return nil
+}
+
+func (f *fakeAPI) CreateLogStream(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) error {
+	f.logStreams[UpdateToken{logGroupName, logStreamName}] = []string{}
+	return nil
+}
+
+func TestClientCreatesLogGroupAndLogStream(t *testing.T) {
+	api := newFakeAPI()
+	retry, _ := GetRetryConfig("", "", "", "")
+	client := NewClient(api, nil, true, retry, "", nil)
+
+	token := UpdateToken{"examplegroup", "examplestream"}
+	client.PrepareLogGroup(token.LogGroup)
+	client.PrepareLogStream(token.LogGroup, token.LogStream)
+	assert.True(t, api.logGroups["examplegroup"])
+
+	events := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("second"), Timestamp: aws.Int64(2)},
+		{Message: aws.String("first"), Timestamp: aws.Int64(1)},
+	}
+	dropped, err := client.PutLogEvents(ChunkID{}, token, events)
+	assert.NoError(t, err)
+	assert.False(t, dropped)
+	assert.Equal(t, []string{"first", "second"}, api.logStreams[token])
+	assert.Equal(t, "nexttoken", client.SequenceToken(token))
+}
+
+func TestClientBacksOffOnThrottling(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	api := newFakeAPI()
+	api.putErrors = map[int]error{1: throttled, 2: throttled}
+	retry, _ := GetRetryConfig("", "2", "10ms", "1s")
+	client := NewClient(api, nil, false, retry, "", nil)
+
+	token := UpdateToken{"examplegroup", "examplestream"}
+	events := []*cloudwatchlogs.InputLogEvent{{Message: aws.String("message"), Timestamp: aws.Int64(1)}}
+	dropped, err := client.PutLogEvents(ChunkID{}, token, events)
+	assert.NoError(t, err)
+	assert.False(t, dropped)
+	assert.Equal(t, 3, api.putCalls)
+	assert.Len(t, delays, 2)
+	assert.True(t, delays[0] <= 10*time.Millisecond)
+	assert.True(t, delays[1] <= 20*time.Millisecond)
+
+	// The error is returned after RetryMaxAttempts.
+	api.putCalls = 0
+	api.putErrors = map[int]error{1: throttled, 2: throttled, 3: throttled}
+	_, err = client.PutLogEvents(ChunkID{}, token, events)
+	assert.Error(t, err)
+	assert.Equal(t, 3, api.putCalls)
+	assert.Equal(t, uint64(0), client.DroppedBatches())
+}
+
+func TestClientTracksDeliveredBatchesPerChunk(t *testing.T) {
+	api := newFakeAPI()
+	retry, _ := GetRetryConfig("", "0", "", "")
+	client := NewClient(api, nil, false, retry, "", nil)
+
+	token := UpdateToken{"examplegroup", "examplestream"}
+	// The events of chunk A span more than 24 hours, so that they are sent
+	// in two batches.
+	a := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("a1"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("a2"), Timestamp: aws.Int64(1 + int64(25*time.Hour/time.Millisecond))},
+	}
+	b := []*cloudwatchlogs.InputLogEvent{{Message: aws.String("a1"), Timestamp: aws.Int64(1)}}
+	chunkA := DigestChunk([]UpdateToken{token}, map[UpdateToken][]*cloudwatchlogs.InputLogEvent{token: a})
+	chunkB := DigestChunk([]UpdateToken{token}, map[UpdateToken][]*cloudwatchlogs.InputLogEvent{token: b})
+	assert.NotEqual(t, chunkA, chunkB)
+
+	// The first batch of A is accepted and the second one fails.
+	api.putErrors = map[int]error{2: awserr.New("ServiceUnavailableException", "unavailable", nil)}
+	_, err := client.PutLogEvents(chunkA, token, a)
+	assert.Error(t, err)
+
+	// B has the same batch as the first one of A, which is sent anyway.
+	_, err = client.PutLogEvents(chunkB, token, b)
+	assert.NoError(t, err)
+	client.ClearDelivered(chunkB)
+
+	// The retry of A sends only the failed batch.
+	_, err = client.PutLogEvents(chunkA, token, a)
+	assert.NoError(t, err)
+	client.ClearDelivered(chunkA)
+	assert.Equal(t, []string{"a1", "a1", "a2"}, api.logStreams[token])
+	assert.Empty(t, client.delivered)
+}
+
+func TestClientUndeliveredEvents(t *testing.T) {
+	api := newFakeAPI()
+	retry, _ := GetRetryConfig("", "0", "", "")
+	client := NewClient(api, nil, false, retry, "", nil)
+
+	token := UpdateToken{"examplegroup", "examplestream"}
+	events := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("a1"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("a2"), Timestamp: aws.Int64(1 + int64(25*time.Hour/time.Millisecond))},
+	}
+	chunk := DigestChunk([]UpdateToken{token}, map[UpdateToken][]*cloudwatchlogs.InputLogEvent{token: events})
+	assert.Equal(t, events, client.UndeliveredEvents(chunk, token, events))
+
+	api.putErrors = map[int]error{2: awserr.New("ServiceUnavailableException", "unavailable", nil)}
+	_, err := client.PutLogEvents(chunk, token, events)
+	assert.Error(t, err)
+	assert.Equal(t, events[1:], client.UndeliveredEvents(chunk, token, events))
+
+	client.ClearDelivered(chunk)
+	assert.Equal(t, events, client.UndeliveredEvents(chunk, token, events))
+}
+
+func TestClientEvictsOldestDeliveredChunk(t *testing.T) {
+	api := newFakeAPI()
+	retry, _ := GetRetryConfig("", "", "", "")
+	client := NewClient(api, nil, false, retry, "", nil)
+
+	token := UpdateToken{"examplegroup", "examplestream"}
+	var first ChunkID
+	for i := 0; i <= maxDeliveredChunks; i++ {
+		events := []*cloudwatchlogs.InputLogEvent{{Message: aws.String("message"), Timestamp: aws.Int64(int64(i))}}
+		chunk := DigestChunk([]UpdateToken{token}, map[UpdateToken][]*cloudwatchlogs.InputLogEvent{token: events})
+		if i == 0 {
+			first = chunk
+		}
+		_, err := client.PutLogEvents(chunk, token, events)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, client.delivered, maxDeliveredChunks)
+	assert.NotContains(t, client.delivered, first)
+}
@@ -0,0 +1,158 @@
+package cwlogs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+)
+
+// DefaultCredentialProcessTimeout is the default of CredentialProcessTimeout.
+const DefaultCredentialProcessTimeout = processcreds.DefaultTimeout
+
+// credentialProcessKillWait bounds the wait for the command to exit after
+// its process group is killed at the timeout.
+var credentialProcessKillWait = 5 * time.Second
+
+// credentialProcessProvider runs an external command which prints
+// credentials in the credential_process format of the AWS CLI. It is used
+// instead of processcreds.ProcessProvider, which writes the stderr of the
+// command to the console rather than to the plugin log.
+type credentialProcessProvider struct {
+	credentials.Expiry
+
+	command string
+	timeout time.Duration
+	// The credentials without Expiration never expire.
+	static bool
+}
+
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      *time.Time
+}
+
+// processCredentialProvider returns the provider which runs
+// CredentialProcess, or nil when it is not configured.
+func processCredentialProvider(opts *CredentialOptions) credentials.Provider {
+	if opts.CredentialProcess == "" {
+		return nil
+	}
+
+	timeout := opts.CredentialProcessTimeout
+	if timeout <= 0 {
+		timeout = DefaultCredentialProcessTimeout
+	}
+
+	return &credentialProcessProvider{
+		command: opts.CredentialProcess,
+		timeout: timeout,
+	}
+}
+
+func (p *credentialProcessProvider) Retrieve() (credentials.Value, error) {
+	out, err := p.run()
+	if err != nil {
+		return credentials.Value{ProviderName: processcreds.ProviderName}, err
+	}
+
+	resp := &credentialProcessOutput{}
+	if err := json.Unmarshal(out, resp); err != nil {
+		return credentials.Value{ProviderName: processcreds.ProviderName},
+			awserr.New(processcreds.ErrCodeProcessProviderParse, "Failed to parse the output of CredentialProcess", err)
+	}
+	if resp.Version != 1 {
+		return credentials.Value{ProviderName: processcreds.ProviderName},
+			awserr.New(processcreds.ErrCodeProcessProviderVersion, fmt.Sprintf("Unsupported CredentialProcess output version: %d", resp.Version), nil)
+	}
+	if resp.AccessKeyID == "" || resp.SecretAccessKey == "" {
+		return credentials.Value{ProviderName: processcreds.ProviderName},
+			awserr.New(processcreds.ErrCodeProcessProviderRequired, "CredentialProcess output lacks AccessKeyId or SecretAccessKey", nil)
+	}
+
+	p.static = resp.Expiration == nil
+	if resp.Expiration != nil {
+		p.SetExpiration(*resp.Expiration, remoteCredentialsExpiryWindow)
+	}
+
+	return credentials.Value{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.SessionToken,
+		ProviderName:    processcreds.ProviderName,
+	}, nil
+}
+
+func (p *credentialProcessProvider) IsExpired() bool {
+	if p.static {
+		return false
+	}
+	return p.Expiry.IsExpired()
+}
+
+// run runs the command with the shell of the platform, and returns its
+// stdout. The stderr of the command is written to the plugin log line by
+// line. At the timeout, the whole process group is killed, because the
+// children of the shell keep stdout and stderr open and Wait would not
+// return until they exit.
+func (p *credentialProcessProvider) run() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := shellCommand(ctx, p.command)
+	setProcessGroup(cmd)
+	cmd.Env = os.Environ()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, awserr.New(processcreds.ErrCodeProcessProviderExecution, "Failed to start CredentialProcess", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		logCredentialProcessStderr(stderr.Bytes())
+		if err != nil {
+			return nil, awserr.New(processcreds.ErrCodeProcessProviderExecution, "CredentialProcess failed", err)
+		}
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		select {
+		case <-done:
+		case <-time.After(credentialProcessKillWait):
+			// A child which left the process group still holds the
+			// output. The buffers are not read, because it may still be
+			// writing to them.
+		}
+		return nil, awserr.New(processcreds.ErrCodeProcessProviderExecution,
+			fmt.Sprintf("CredentialProcess timed out after %v", p.timeout), nil)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func logCredentialProcessStderr(stderr []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fmt.Printf("[CredentialProcess] %s\n", line)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package cwlogs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessCredentialProviderNotConfigured(t *testing.T) {
+	assert.Nil(t, processCredentialProvider(&CredentialOptions{}))
+}
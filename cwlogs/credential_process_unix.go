@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package cwlogs
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command with sh, as the AWS CLI does.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// setProcessGroup runs the command in a new process group, so that its
+// children can be killed together with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
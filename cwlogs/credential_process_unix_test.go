@@ -0,0 +1,168 @@
+//go:build !windows
+// +build !windows
+
+package cwlogs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCredentialProcess writes a shell script which stands in for
+// a credential helper, and returns its path.
+func writeCredentialProcess(t *testing.T, dir, script string) string {
+	path := filepath.Join(dir, "credential_process.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	return path
+}
+
+func TestCredentialProcess(t *testing.T) {
+	defer setenv(noEnvCredentials)()
+	dir, err := ioutil.TempDir("", "credentialprocess")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The script counts its runs, and the credentials expire within
+	// the expiry window.
+	counter := filepath.Join(dir, "count")
+	expiration := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	command := writeCredentialProcess(t, dir, fmt.Sprintf(`echo run >> %s
+echo "fetching credentials" >&2
+cat <<EOF
+{"Version": 1, "AccessKeyId": "PROCESSAKID", "SecretAccessKey": "PROCESSSECRET", "SessionToken": "PROCESSTOKEN", "Expiration": "%s"}
+EOF
+`, counter, expiration))
+
+	opts := &CredentialOptions{
+		CredentialProcess: command,
+		CredentialChain:   []string{"process"},
+	}
+	creds, err := GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "PROCESSAKID", value.AccessKeyID)
+	assert.Equal(t, "PROCESSSECRET", value.SecretAccessKey)
+	assert.Equal(t, "PROCESSTOKEN", value.SessionToken)
+	assert.Equal(t, processcreds.ProviderName, value.ProviderName)
+
+	// The credentials are refreshed by running the command again.
+	assert.True(t, creds.IsExpired())
+	runs, _ := ioutil.ReadFile(counter)
+	before := len(runs)
+	creds.Get()
+	runs, _ = ioutil.ReadFile(counter)
+	assert.Equal(t, before+len("run\n"), len(runs))
+}
+
+func TestCredentialProcessWithoutExpiration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentialprocess")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := writeCredentialProcess(t, dir, `echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "SECRET"}'`)
+	p := processCredentialProvider(&CredentialOptions{CredentialProcess: command})
+	value, err := p.Retrieve()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "AKID", value.AccessKeyID)
+	assert.False(t, p.IsExpired())
+}
+
+func TestCredentialProcessErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentialprocess")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, script := range []string{
+		"echo 'access denied' >&2; exit 1",
+		"echo 'not json'",
+		`echo '{"Version": 2, "AccessKeyId": "AKID", "SecretAccessKey": "SECRET"}'`,
+		`echo '{"Version": 1, "AccessKeyId": "AKID"}'`,
+	} {
+		command := writeCredentialProcess(t, dir, script)
+		p := processCredentialProvider(&CredentialOptions{CredentialProcess: command})
+		_, err := p.Retrieve()
+		assert.Error(t, err, script)
+	}
+}
+
+func TestCredentialProcessTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentialprocess")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := writeCredentialProcess(t, dir, "exec sleep 5")
+	p := processCredentialProvider(&CredentialOptions{
+		CredentialProcess:        command,
+		CredentialProcessTimeout: 100 * time.Millisecond,
+	})
+	start := time.Now()
+	_, err = p.Retrieve()
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+// processExited reports whether the process has exited, including as a
+// zombie which no one has reaped.
+func processExited(pid string) bool {
+	out, err := exec.Command("ps", "-o", "stat=", "-p", pid).Output()
+	return err != nil || strings.HasPrefix(strings.TrimSpace(string(out)), "Z")
+}
+
+func TestCredentialProcessTimeoutKillsChildren(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentialprocess")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The child of sh keeps stdout open after sh is killed.
+	pidFile := filepath.Join(dir, "pid")
+	command := writeCredentialProcess(t, dir, "sleep 30 &\necho $! > "+pidFile+"\nwait")
+	p := processCredentialProvider(&CredentialOptions{
+		CredentialProcess:        command,
+		CredentialProcessTimeout: 500 * time.Millisecond,
+	})
+	start := time.Now()
+	_, err = p.Retrieve()
+	assert.Error(t, err)
+	// The command exits without waiting for credentialProcessKillWait.
+	assert.True(t, time.Since(start) < credentialProcessKillWait, "%v", time.Since(start))
+
+	pid, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !processExited(strings.TrimSpace(string(pid))) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the child %s of CredentialProcess is still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,22 @@
+package cwlogs
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command with cmd.exe, as the AWS CLI does.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd.exe", "/C", command)
+}
+
+// setProcessGroup does nothing on Windows, which has no process groups
+// to kill at once.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
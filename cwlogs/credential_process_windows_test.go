@@ -0,0 +1,31 @@
+package cwlogs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialProcessWithCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentialprocess")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := filepath.Join(dir, "credential_process.cmd")
+	script := "@echo {\"Version\": 1, \"AccessKeyId\": \"AKID\", \"SecretAccessKey\": \"SECRET\"}\r\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0700); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	p := processCredentialProvider(&CredentialOptions{CredentialProcess: command})
+	value, err := p.Retrieve()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "AKID", value.AccessKeyID)
+	assert.False(t, p.IsExpired())
+}
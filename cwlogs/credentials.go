@@ -0,0 +1,149 @@
+package cwlogs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+)
+
+// CredentialOptions holds the parameters to create credentials.
+type CredentialOptions struct {
+	AccessKeyID         string
+	SecretAccessKey     string
+	Credential          string
+	Profile             string
+	EC2MetadataEndpoint string
+	Region              string
+	Endpoints           *EndpointConf
+	CredentialChain     []string
+
+	CredentialProcess        string
+	CredentialProcessTimeout time.Duration
+}
+
+const (
+	defaultEC2MetadataEndpoint = "http://169.254.169.254"
+	ecsCredentialsEndpoint     = "http://169.254.170.2"
+	// Remote credentials are refreshed this long before they expire.
+	remoteCredentialsExpiryWindow = 5 * time.Minute
+)
+
+// GetCredentials creates the credentials with the providers of
+// CredentialChain. The providers which are not configured are skipped.
+func GetCredentials(opts *CredentialOptions) (*credentials.Credentials, error) {
+	chain := opts.CredentialChain
+	if len(chain) == 0 {
+		chain = defaultCredentialChain
+	}
+
+	var providers []credentials.Provider
+	for _, name := range chain {
+		var provider credentials.Provider
+		switch name {
+		case "shared":
+			if opts.Credential != "" || opts.Profile != "" {
+				shared, err := newSharedConfigProvider(opts)
+				if err != nil {
+					fmt.Println("[CredentialChain] ERROR:", err)
+					return nil, fmt.Errorf("Failed to load the shared credentials")
+				}
+				provider = shared
+			}
+		case "static":
+			if !(opts.AccessKeyID == "" && opts.SecretAccessKey == "") {
+				provider = &credentials.StaticProvider{Value: credentials.Value{
+					AccessKeyID:     opts.AccessKeyID,
+					SecretAccessKey: opts.SecretAccessKey,
+				}}
+			}
+		case "process":
+			provider = processCredentialProvider(opts)
+		case "env":
+			provider = &credentials.EnvProvider{}
+		case "webidentity":
+			provider = webIdentityCredentialProvider(opts)
+		case "ecs":
+			provider = containerCredentialProvider()
+		case "ec2":
+			provider = ec2RoleCredentialProvider(opts.EC2MetadataEndpoint)
+		}
+		if provider != nil {
+			providers = append(providers, provider)
+		}
+	}
+
+	creds := credentials.NewCredentials(&credentials.ChainProvider{
+		VerboseErrors: true,
+		Providers:     providers,
+	})
+	if _, err := creds.Get(); err != nil {
+		fmt.Println("[CredentialChain] ERROR:", err)
+		return nil, fmt.Errorf("Failed to create credentials")
+	}
+
+	return creds, nil
+}
+
+var defaultCredentialChain = []string{"shared", "static", "process", "env", "webidentity", "ecs", "ec2"}
+
+// ParseCredentialChain parses a comma separated list of credential
+// providers, e.g. "webidentity,ec2".
+func ParseCredentialChain(chain string) ([]string, error) {
+	if chain == "" {
+		return nil, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(chain, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "shared", "static", "process", "env", "webidentity", "ecs", "ec2":
+			names = append(names, name)
+		default:
+			return nil, fmt.Errorf("Unknown credential provider in CredentialChain: %s", name)
+		}
+	}
+
+	return names, nil
+}
+
+// containerCredentialProvider returns the provider of the ECS task role,
+// or nil when the container credentials endpoint is not available.
+func containerCredentialProvider() credentials.Provider {
+	var endpoint string
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		endpoint = ecsCredentialsEndpoint + uri
+	} else if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		endpoint = uri
+	} else {
+		return nil
+	}
+
+	return endpointcreds.NewProviderClient(*defaults.Config(), defaults.Handlers(), endpoint,
+		func(p *endpointcreds.Provider) {
+			p.ExpiryWindow = remoteCredentialsExpiryWindow
+			p.AuthorizationToken = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		},
+	)
+}
+
+// ec2RoleCredentialProvider returns the provider of the EC2 instance
+// profile which is served by the instance metadata endpoint.
+func ec2RoleCredentialProvider(metadataEndpoint string) credentials.Provider {
+	if metadataEndpoint == "" {
+		metadataEndpoint = defaultEC2MetadataEndpoint
+	}
+	endpoint := strings.TrimSuffix(metadataEndpoint, "/") + "/latest"
+
+	return &ec2rolecreds.EC2RoleProvider{
+		Client:       ec2metadata.NewClient(*defaults.Config(), defaults.Handlers(), endpoint, ""),
+		ExpiryWindow: remoteCredentialsExpiryWindow,
+	}
+}
@@ -0,0 +1,173 @@
+package cwlogs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/stretchr/testify/assert"
+)
+
+// setenv sets environment variables and returns a function to restore them.
+func setenv(vars map[string]string) func() {
+	saved := make(map[string]*string)
+	for key, value := range vars {
+		if old, ok := os.LookupEnv(key); ok {
+			saved[key] = &old
+		} else {
+			saved[key] = nil
+		}
+		if value == "" {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, value)
+		}
+	}
+	return func() {
+		for key, old := range saved {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}
+}
+
+var noEnvCredentials = map[string]string{
+	"AWS_ACCESS_KEY_ID":                      "",
+	"AWS_ACCESS_KEY":                         "",
+	"AWS_SECRET_ACCESS_KEY":                  "",
+	"AWS_SECRET_KEY":                         "",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN":      "",
+	"AWS_ROLE_ARN":                           "",
+	"AWS_WEB_IDENTITY_TOKEN_FILE":            "",
+	"AWS_PROFILE":                            "",
+	"AWS_SHARED_CREDENTIALS_FILE":            "",
+	"AWS_CONFIG_FILE":                        "",
+}
+
+func TestGetCredentialsFromEC2InstanceMetadata(t *testing.T) {
+	defer setenv(noEnvCredentials)()
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "examplerole")
+		case "/latest/meta-data/iam/security-credentials/examplerole":
+			fmt.Fprintf(w, `{"Code":"Success","Type":"AWS-HMAC","AccessKeyId":"EC2AKID","SecretAccessKey":"EC2SECRET","Token":"EC2TOKEN","Expiration":"%s"}`, expiration)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	creds, err := GetCredentials(&CredentialOptions{EC2MetadataEndpoint: server.URL})
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "EC2AKID", value.AccessKeyID)
+	assert.Equal(t, "EC2SECRET", value.SecretAccessKey)
+	assert.Equal(t, "EC2TOKEN", value.SessionToken)
+	assert.Equal(t, ec2rolecreds.ProviderName, value.ProviderName)
+}
+
+func TestGetCredentialsFromContainerEndpoint(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		fmt.Fprintf(w, `{"AccessKeyId":"ECSAKID","SecretAccessKey":"ECSSECRET","Token":"ECSTOKEN","Expiration":"%s"}`, expiration)
+	}))
+	defer server.Close()
+
+	env := map[string]string{}
+	for key, value := range noEnvCredentials {
+		env[key] = value
+	}
+	env["AWS_CONTAINER_CREDENTIALS_FULL_URI"] = server.URL + "/v2/credentials"
+	env["AWS_CONTAINER_AUTHORIZATION_TOKEN"] = "exampletoken"
+	defer setenv(env)()
+
+	// The container credentials endpoint takes precedence over the instance metadata.
+	creds, err := GetCredentials(&CredentialOptions{EC2MetadataEndpoint: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "ECSAKID", value.AccessKeyID)
+	assert.Equal(t, "ECSTOKEN", value.SessionToken)
+	assert.Equal(t, endpointcreds.ProviderName, value.ProviderName)
+	assert.Equal(t, "exampletoken", authorization)
+}
+
+func TestGetCredentialsFailsWithoutAnyProvider(t *testing.T) {
+	defer setenv(noEnvCredentials)()
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := GetCredentials(&CredentialOptions{EC2MetadataEndpoint: server.URL})
+	assert.Error(t, err)
+}
+
+func TestParseCredentialChain(t *testing.T) {
+	chain, err := ParseCredentialChain("")
+	assert.NoError(t, err)
+	assert.Nil(t, chain)
+
+	chain, err = ParseCredentialChain("WebIdentity, ec2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"webidentity", "ec2"}, chain)
+
+	_, err = ParseCredentialChain("env,unknown")
+	assert.Error(t, err)
+}
+
+func TestGetCredentialsInChainOrder(t *testing.T) {
+	env := map[string]string{}
+	for key, value := range noEnvCredentials {
+		env[key] = value
+	}
+	env["AWS_ACCESS_KEY_ID"] = "ENVAKID"
+	env["AWS_SECRET_ACCESS_KEY"] = "ENVSECRET"
+	defer setenv(env)()
+
+	opts := &CredentialOptions{AccessKeyID: "STATICAKID", SecretAccessKey: "STATICSECRET"}
+	creds, err := GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ := creds.Get()
+	assert.Equal(t, "STATICAKID", value.AccessKeyID)
+
+	opts.CredentialChain = []string{"env", "static"}
+	creds, err = GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ = creds.Get()
+	assert.Equal(t, "ENVAKID", value.AccessKeyID)
+
+	// Providers which are not configured are skipped.
+	opts = &CredentialOptions{CredentialChain: []string{"shared", "static", "env"}}
+	creds, err = GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ = creds.Get()
+	assert.Equal(t, "ENVAKID", value.AccessKeyID)
+}
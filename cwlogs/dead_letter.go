@@ -0,0 +1,226 @@
+package cwlogs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/json-iterator/go"
+)
+
+const (
+	defaultDeadLetterFileSize = 10 * 1024 * 1024
+	defaultDeadLetterMaxSize  = 100 * 1024 * 1024
+
+	DeadLetterFilePrefix = "dead-letter-"
+	DeadLetterFileSuffix = ".ndjson"
+	// The file names are sorted in the order of creation.
+	deadLetterTimeFormat = "20060102T150405.000000000"
+)
+
+// DeadLetterEvent is written to the dead letter files as a line of JSON.
+type DeadLetterEvent struct {
+	LogGroup     string `json:"log_group"`
+	LogStream    string `json:"log_stream"`
+	ErrorCode    string `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+	FailedAt     string `json:"failed_at"`
+	Timestamp    int64  `json:"timestamp"`
+	Message      string `json:"message"`
+}
+
+// DeadLetterQueue keeps the batches which could not be delivered in
+// DeadLetterDir. The files are rotated by fileSize, and the oldest files
+// are removed when the total size exceeds maxSize. The file names begin with
+// prefix, so that the output instances sharing DeadLetterDir never remove
+// the files of each other.
+type DeadLetterQueue struct {
+	dir      string
+	prefix   string
+	fileSize int64
+	maxSize  int64
+
+	mu          sync.Mutex
+	current     *os.File
+	currentSize int64
+}
+
+// GetDeadLetterConfig returns nil when dir is not specified. instance is the
+// number of the output instance which owns the queue.
+func GetDeadLetterConfig(dir string, instance int, fileSize, maxSize string) (*DeadLetterQueue, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	q := &DeadLetterQueue{dir: dir, prefix: fmt.Sprintf("%s%d-", DeadLetterFilePrefix, instance)}
+	var err error
+	if q.fileSize, err = ParseSizeParameter("DeadLetterFileSize", fileSize, defaultDeadLetterFileSize); err != nil {
+		return nil, err
+	}
+	if q.maxSize, err = ParseSizeParameter("DeadLetterMaxSize", maxSize, defaultDeadLetterMaxSize); err != nil {
+		return nil, err
+	}
+	if q.maxSize < q.fileSize {
+		return nil, fmt.Errorf("DeadLetterMaxSize must not be smaller than DeadLetterFileSize")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create DeadLetterDir: %v", err)
+	}
+
+	return q, nil
+}
+
+// ParseSizeParameter parses a size in bytes with an optional unit, e.g.
+// "512K", "10M" or "1G".
+func ParseSizeParameter(name, value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1024
+	case strings.HasSuffix(s, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("Invalid %s: %s", name, value)
+	}
+	return n * unit, nil
+}
+
+// awsErrorCode returns the AWS error code of err, or "" for the other
+// errors.
+func awsErrorCode(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+// write appends the events of batch which failed with err.
+func (q *DeadLetterQueue) write(token UpdateToken, batch []*cloudwatchlogs.InputLogEvent, cause error) error {
+	failedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	message := cause.Error()
+	if awsErr, ok := cause.(awserr.Error); ok {
+		message = awsErr.Message()
+	}
+
+	var data []byte
+	for _, event := range batch {
+		line, err := jsoniter.Marshal(DeadLetterEvent{
+			LogGroup:     token.LogGroup,
+			LogStream:    token.LogStream,
+			ErrorCode:    awsErrorCode(cause),
+			ErrorMessage: message,
+			FailedAt:     failedAt,
+			Timestamp:    *event.Timestamp,
+			Message:      *event.Message,
+		})
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.current == nil || (q.currentSize > 0 && q.currentSize+int64(len(data)) > q.fileSize) {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := q.current.Write(data)
+	q.currentSize += int64(n)
+	if err != nil {
+		return err
+	}
+
+	return q.evict()
+}
+
+// rotate closes the current file and creates a new one.
+func (q *DeadLetterQueue) rotate() error {
+	if q.current != nil {
+		q.current.Close()
+		q.current = nil
+	}
+
+	name := q.prefix + time.Now().UTC().Format(deadLetterTimeFormat) + DeadLetterFileSuffix
+	f, err := os.OpenFile(filepath.Join(q.dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	q.current = f
+	q.currentSize = 0
+	return nil
+}
+
+// evict removes the oldest files of the queue until their total size fits
+// in maxSize. The current file is never removed.
+func (q *DeadLetterQueue) evict() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	sizes := make(map[string]int64)
+	var total int64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, q.prefix) || !strings.HasSuffix(name, DeadLetterFileSuffix) {
+			continue
+		}
+		names = append(names, name)
+		sizes[name] = f.Size()
+		total += f.Size()
+	}
+	sort.Strings(names)
+
+	current := filepath.Base(q.current.Name())
+	for _, name := range names {
+		if total <= q.maxSize {
+			break
+		}
+		if name == current {
+			continue
+		}
+		if err := os.Remove(filepath.Join(q.dir, name)); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the dead letter file %s to keep DeadLetterMaxSize\n", name)
+		total -= sizes[name]
+	}
+
+	return nil
+}
+
+func (q *DeadLetterQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current != nil {
+		q.current.Close()
+		q.current = nil
+	}
+}
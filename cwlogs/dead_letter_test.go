@@ -0,0 +1,193 @@
+package cwlogs
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func deadLetterFiles(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, DeadLetterFilePrefix+"*"+DeadLetterFileSuffix))
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	return matches
+}
+
+func readDeadLetterEvents(t *testing.T, path string) []DeadLetterEvent {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer f.Close()
+
+	var events []DeadLetterEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event DeadLetterEvent
+		if err := jsoniter.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestParseSizeParameter(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"":      42,
+		"1000":  1000,
+		"512K":  512 * 1024,
+		"10M":   10 * 1024 * 1024,
+		"10MB":  10 * 1024 * 1024,
+		"1g":    1024 * 1024 * 1024,
+		" 2KB ": 2048,
+	} {
+		size, err := ParseSizeParameter("Size", value, 42)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, size, value)
+	}
+
+	for _, value := range []string{"M", "-1M", "0", "ten", "9223372036854775807K", "8589934592G"} {
+		_, err := ParseSizeParameter("Size", value, 42)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestGetDeadLetterConfig(t *testing.T) {
+	q, err := GetDeadLetterConfig("", 0, "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err = GetDeadLetterConfig(filepath.Join(dir, "nested"), 0, "", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, int64(defaultDeadLetterFileSize), q.fileSize)
+	assert.Equal(t, int64(defaultDeadLetterMaxSize), q.maxSize)
+	_, err = os.Stat(filepath.Join(dir, "nested"))
+	assert.NoError(t, err)
+
+	_, err = GetDeadLetterConfig(dir, 0, "10M", "1M")
+	assert.Error(t, err)
+}
+
+func TestDeadLetterQueueWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, _ := GetDeadLetterConfig(dir, 0, "", "")
+	defer q.Close()
+	batch := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String(`{"mykey":"first"}`), Timestamp: aws.Int64(1552212672000)},
+		{Message: aws.String(`{"mykey":"second"}`), Timestamp: aws.Int64(1552212673000)},
+	}
+	cause := awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid batch", nil)
+	assert.NoError(t, q.write(UpdateToken{"examplegroup", "examplestream"}, batch, cause))
+	assert.NoError(t, q.write(UpdateToken{"examplegroup", "examplestream"}, batch[:1], errors.New("connection reset")))
+
+	files := deadLetterFiles(t, dir)
+	assert.Len(t, files, 1)
+	events := readDeadLetterEvents(t, files[0])
+	assert.Len(t, events, 3)
+	assert.Equal(t, "examplegroup", events[0].LogGroup)
+	assert.Equal(t, "examplestream", events[0].LogStream)
+	assert.Equal(t, cloudwatchlogs.ErrCodeInvalidParameterException, events[0].ErrorCode)
+	assert.Equal(t, "invalid batch", events[0].ErrorMessage)
+	assert.Equal(t, int64(1552212672000), events[0].Timestamp)
+	assert.Equal(t, `{"mykey":"first"}`, events[0].Message)
+	_, err = time.Parse(time.RFC3339Nano, events[0].FailedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"mykey":"second"}`, events[1].Message)
+	assert.Equal(t, "", events[2].ErrorCode)
+	assert.Equal(t, "connection reset", events[2].ErrorMessage)
+}
+
+func TestDeadLetterQueueRotatesAndEvicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files of other names are left alone.
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte(strings.Repeat("x", 4096)), 0644)
+
+	q, _ := GetDeadLetterConfig(dir, 0, "1K", "3K")
+	defer q.Close()
+	cause := awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid batch", nil)
+	for i := 0; i < 20; i++ {
+		batch := []*cloudwatchlogs.InputLogEvent{
+			{Message: aws.String(strings.Repeat("a", 300)), Timestamp: aws.Int64(int64(i))},
+		}
+		assert.NoError(t, q.write(UpdateToken{"examplegroup", "examplestream"}, batch, cause))
+		time.Sleep(time.Millisecond)
+	}
+
+	files := deadLetterFiles(t, dir)
+	var total int64
+	for _, file := range files {
+		info, _ := os.Stat(file)
+		assert.True(t, info.Size() <= 1024, file)
+		total += info.Size()
+	}
+	assert.True(t, len(files) > 1)
+	assert.True(t, total <= 3*1024)
+
+	// The newest events are kept.
+	events := readDeadLetterEvents(t, files[len(files)-1])
+	assert.Equal(t, int64(19), events[len(events)-1].Timestamp)
+	_, err = os.Stat(filepath.Join(dir, "README"))
+	assert.NoError(t, err)
+}
+
+func TestDeadLetterQueuesShareDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cause := awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid batch", nil)
+	batch := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String(strings.Repeat("a", 300)), Timestamp: aws.Int64(0)},
+	}
+
+	other, _ := GetDeadLetterConfig(dir, 1, "1K", "1K")
+	assert.NoError(t, other.write(UpdateToken{"othergroup", "otherstream"}, batch, cause))
+	other.Close()
+	otherFiles := deadLetterFiles(t, dir)
+	assert.Len(t, otherFiles, 1)
+
+	q, _ := GetDeadLetterConfig(dir, 0, "1K", "1K")
+	defer q.Close()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, q.write(UpdateToken{"examplegroup", "examplestream"}, batch, cause))
+		time.Sleep(time.Millisecond)
+	}
+
+	// The file of the other queue is not removed to keep DeadLetterMaxSize.
+	_, err = os.Stat(otherFiles[0])
+	assert.NoError(t, err)
+	assert.True(t, len(deadLetterFiles(t, dir)) <= 3)
+}
@@ -0,0 +1,25 @@
+package cwlogs
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// EMFLogFormat is the value of the x-amzn-logs-format header, with which
+// CloudWatch Logs extracts metrics from the Embedded Metric Format events
+// of a PutLogEvents call.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Generation_PutLogEvents.html
+const EMFLogFormat = "json/emf"
+
+// AddEMFHeader makes the PutLogEvents calls of svc send the events as
+// Embedded Metric Format documents.
+func AddEMFHeader(svc *cloudwatchlogs.CloudWatchLogs) {
+	svc.Handlers.Build.PushBackNamed(request.NamedHandler{
+		Name: "cwlogs.EMFHeader",
+		Fn: func(r *request.Request) {
+			if r.Operation.Name == "PutLogEvents" {
+				r.HTTPRequest.Header.Set("x-amzn-logs-format", EMFLogFormat)
+			}
+		},
+	})
+}
@@ -0,0 +1,61 @@
+package cwlogs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddEMFHeader(t *testing.T) {
+	var mu sync.Mutex
+	formats := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+		formats[operation] = r.Header.Get("x-amzn-logs-format")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	svc := cloudwatchlogs.New(session.New(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	}))
+	AddEMFHeader(svc)
+
+	_, err := svc.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String("group"),
+		LogStreamName: aws.String("stream"),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{
+			{Message: aws.String(`{"latency":1}`), Timestamp: aws.Int64(1552212672000)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	_, err = svc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String("group"),
+		LogStreamName: aws.String("stream"),
+	})
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]string{
+		"PutLogEvents":    EMFLogFormat,
+		"CreateLogStream": "",
+	}, formats)
+}
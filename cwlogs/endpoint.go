@@ -0,0 +1,105 @@
+package cwlogs
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Service names which are used to pick up the endpoint.
+const (
+	ServiceCloudWatchLogs = "logs"
+	ServiceSTS            = "sts"
+)
+
+// EndpointConf holds the endpoints of CloudWatch Logs and STS, e.g. VPC
+// interface endpoints, FIPS endpoints or local emulators, and the HTTP
+// client to reach them.
+type EndpointConf struct {
+	endpoint    string
+	stsEndpoint string
+	useFIPS     bool
+	disableSSL  bool
+	HTTPClient  *http.Client
+}
+
+func GetEndpointConfig(endpoint, stsEndpoint, useFIPS, disableSSL string) (*EndpointConf, error) {
+	conf := &EndpointConf{
+		endpoint:    endpoint,
+		stsEndpoint: stsEndpoint,
+	}
+
+	var err error
+	if useFIPS != "" {
+		if conf.useFIPS, err = strconv.ParseBool(useFIPS); err != nil {
+			return nil, fmt.Errorf("Invalid UseFIPS: %s", useFIPS)
+		}
+	}
+	if disableSSL != "" {
+		if conf.disableSSL, err = strconv.ParseBool(disableSSL); err != nil {
+			return nil, fmt.Errorf("Invalid DisableSSL: %s", disableSSL)
+		}
+	}
+
+	return conf, nil
+}
+
+// Config returns the aws.Config for service in region. The endpoint which
+// is specified explicitly takes precedence over the FIPS endpoint. A nil
+// EndpointConf uses the default endpoints.
+func (c *EndpointConf) Config(service, region string) *aws.Config {
+	config := &aws.Config{Region: aws.String(region)}
+	if c == nil {
+		return config
+	}
+
+	config.DisableSSL = aws.Bool(c.disableSSL)
+	if c.HTTPClient != nil {
+		config.HTTPClient = c.HTTPClient
+	}
+	endpoint := c.endpoint
+	if service == ServiceSTS {
+		endpoint = c.stsEndpoint
+	}
+	switch {
+	case endpoint != "":
+		config.Endpoint = aws.String(endpoint)
+	case c.useFIPS:
+		config.Endpoint = aws.String(fipsEndpoint(service, region))
+	}
+
+	return config
+}
+
+// fipsRegions are the regions of the US and GovCloud (US) partitions,
+// which provide the FIPS endpoints.
+var fipsRegions = map[string]bool{
+	"us-east-1":     true,
+	"us-east-2":     true,
+	"us-west-1":     true,
+	"us-west-2":     true,
+	"us-gov-east-1": true,
+	"us-gov-west-1": true,
+}
+
+// CheckRegion returns an error when UseFIPS is specified for a region
+// which has no FIPS endpoints, unless the endpoints of both CloudWatch
+// Logs and STS are specified explicitly. c may be nil.
+func (c *EndpointConf) CheckRegion(region string) error {
+	if c == nil || !c.useFIPS || (c.endpoint != "" && c.stsEndpoint != "") {
+		return nil
+	}
+	if !fipsRegions[region] {
+		return fmt.Errorf("UseFIPS is not available in region %s: FIPS endpoints are provided only in the US and GovCloud (US) regions", region)
+	}
+	return nil
+}
+
+// fipsEndpoint returns the host name of the FIPS endpoint, which is
+// provided in the US and GovCloud (US) regions. The scheme is added by
+// the SDK according to DisableSSL.
+func fipsEndpoint(service, region string) string {
+	return fmt.Sprintf("%s-fips.%s.amazonaws.com", service, region)
+}
@@ -0,0 +1,71 @@
+package cwlogs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEndpointConfig(t *testing.T) {
+	conf, err := GetEndpointConfig("", "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &EndpointConf{}, conf)
+
+	conf, err = GetEndpointConfig("https://vpce.example.com", "https://sts.example.com", "true", "false")
+	assert.NoError(t, err)
+	assert.Equal(t, &EndpointConf{
+		endpoint:    "https://vpce.example.com",
+		stsEndpoint: "https://sts.example.com",
+		useFIPS:     true,
+	}, conf)
+
+	_, err = GetEndpointConfig("", "", "yes please", "")
+	assert.Error(t, err)
+	_, err = GetEndpointConfig("", "", "", "maybe")
+	assert.Error(t, err)
+}
+
+func TestEndpointConfig(t *testing.T) {
+	endpointOf := func(c *EndpointConf, service, region string) string {
+		config := c.Config(service, region)
+		if service == ServiceSTS {
+			return session.New(config).ClientConfig(ServiceSTS).Endpoint
+		}
+		return cloudwatchlogs.New(session.New(config)).Endpoint
+	}
+
+	var defaults *EndpointConf
+	assert.Equal(t, "https://logs.us-east-1.amazonaws.com", endpointOf(defaults, ServiceCloudWatchLogs, "us-east-1"))
+
+	fips := &EndpointConf{useFIPS: true}
+	assert.Equal(t, "https://logs-fips.us-east-1.amazonaws.com", endpointOf(fips, ServiceCloudWatchLogs, "us-east-1"))
+	assert.Equal(t, "https://sts-fips.us-west-2.amazonaws.com", endpointOf(fips, ServiceSTS, "us-west-2"))
+
+	// The endpoints which are specified explicitly take precedence.
+	custom := &EndpointConf{endpoint: "vpce-1234.logs.us-east-1.vpce.amazonaws.com", stsEndpoint: "https://sts.example.com", useFIPS: true}
+	assert.Equal(t, "https://vpce-1234.logs.us-east-1.vpce.amazonaws.com", endpointOf(custom, ServiceCloudWatchLogs, "us-east-1"))
+	assert.Equal(t, "https://sts.example.com", endpointOf(custom, ServiceSTS, "us-east-1"))
+
+	custom.disableSSL = true
+	assert.Equal(t, "http://vpce-1234.logs.us-east-1.vpce.amazonaws.com", endpointOf(custom, ServiceCloudWatchLogs, "us-east-1"))
+}
+
+func TestEndpointConfigCheckRegion(t *testing.T) {
+	var defaults *EndpointConf
+	assert.NoError(t, defaults.CheckRegion("eu-west-1"))
+	assert.NoError(t, (&EndpointConf{}).CheckRegion("eu-west-1"))
+
+	fips := &EndpointConf{useFIPS: true}
+	for _, region := range []string{"us-east-1", "us-east-2", "us-west-1", "us-west-2", "us-gov-east-1", "us-gov-west-1"} {
+		assert.NoError(t, fips.CheckRegion(region), region)
+	}
+	for _, region := range []string{"eu-west-1", "ap-northeast-1", "cn-north-1", "us-iso-east-1"} {
+		assert.Error(t, fips.CheckRegion(region), region)
+	}
+
+	// STS still uses the FIPS endpoint unless STSEndpoint is specified.
+	assert.Error(t, (&EndpointConf{endpoint: "https://vpce.example.com", useFIPS: true}).CheckRegion("eu-west-1"))
+	assert.NoError(t, (&EndpointConf{endpoint: "https://vpce.example.com", stsEndpoint: "https://sts.example.com", useFIPS: true}).CheckRegion("eu-west-1"))
+}
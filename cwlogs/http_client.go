@@ -0,0 +1,190 @@
+package cwlogs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConnectTimeout      = 10 * time.Second
+	defaultRequestTimeout      = time.Minute
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// HTTPClientConf holds the parameters of the HTTP client which is used for
+// CloudWatch Logs and STS.
+type HTTPClientConf struct {
+	proxy               *url.URL
+	noProxy             []string
+	caBundle            string
+	connectTimeout      time.Duration
+	requestTimeout      time.Duration
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+func GetHTTPClientConfig(httpProxy, noProxy, caBundle, connectTimeout, requestTimeout, maxIdleConns, maxIdleConnsPerHost, idleConnTimeout string) (*HTTPClientConf, error) {
+	conf := &HTTPClientConf{caBundle: caBundle}
+	var err error
+
+	if httpProxy != "" {
+		conf.proxy, err = url.Parse(httpProxy)
+		if err != nil || conf.proxy.Host == "" {
+			return nil, fmt.Errorf("Invalid HTTPProxy: %s", SecretProxyURL(httpProxy))
+		}
+	}
+	for _, host := range strings.Split(noProxy, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			conf.noProxy = append(conf.noProxy, host)
+		}
+	}
+
+	if conf.connectTimeout, err = ParseDurationParameter("ConnectTimeout", connectTimeout, defaultConnectTimeout); err != nil {
+		return nil, err
+	}
+	if conf.requestTimeout, err = ParseDurationParameter("RequestTimeout", requestTimeout, defaultRequestTimeout); err != nil {
+		return nil, err
+	}
+	if conf.idleConnTimeout, err = ParseDurationParameter("IdleConnTimeout", idleConnTimeout, defaultIdleConnTimeout); err != nil {
+		return nil, err
+	}
+	if conf.maxIdleConns, err = ParseIntParameter("MaxIdleConns", maxIdleConns, defaultMaxIdleConns); err != nil {
+		return nil, err
+	}
+	if conf.maxIdleConnsPerHost, err = ParseIntParameter("MaxIdleConnsPerHost", maxIdleConnsPerHost, defaultMaxIdleConnsPerHost); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+func ParseDurationParameter(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid %s: %s", name, value)
+	}
+	return d, nil
+}
+
+func ParseIntParameter(name, value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %s: %s", name, value)
+	}
+	return n, nil
+}
+
+// SecretProxyURL hides the password of the proxy URL for logging.
+func SecretProxyURL(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// Client creates the HTTP client. The certificates in CABundle are trusted
+// in addition to the system ones.
+func (c *HTTPClientConf) Client() (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: c.proxyFunc(),
+		DialContext: (&net.Dialer{
+			Timeout:   c.connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   c.connectTimeout,
+		MaxIdleConns:          c.maxIdleConns,
+		MaxIdleConnsPerHost:   c.maxIdleConnsPerHost,
+		IdleConnTimeout:       c.idleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if c.caBundle != "" {
+		pem, err := ioutil.ReadFile(c.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CABundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate is found in CABundle: %s", c.caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   c.requestTimeout,
+	}, nil
+}
+
+// proxyFromEnvironment is replaced in the tests because
+// http.ProxyFromEnvironment reads the environment variables only once.
+var proxyFromEnvironment = http.ProxyFromEnvironment
+
+// proxyFunc returns the proxy of the requests. Without HTTPProxy, the proxy
+// environment variables are used as the default client of the SDK does.
+// NoProxy applies to both proxies.
+func (c *HTTPClientConf) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if c.proxy == nil && len(c.noProxy) == 0 {
+		return proxyFromEnvironment
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if c.bypassProxy(req.URL.Hostname()) {
+			return nil, nil
+		}
+		if c.proxy == nil {
+			return proxyFromEnvironment(req)
+		}
+		return c.proxy, nil
+	}
+}
+
+// bypassProxy reports whether host matches NoProxy. An entry of NoProxy is
+// "*", a host name which also matches its subdomains, or an IP address or
+// CIDR block.
+func (c *HTTPClientConf) bypassProxy(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range c.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if ip != nil {
+			if _, block, err := net.ParseCIDR(entry); err == nil && block.Contains(ip) {
+				return true
+			}
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package cwlogs
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/json-iterator/go"
+)
+
+// Reasons why CloudWatch Logs rejected events.
+const (
+	rejectedTooOld  = "too_old"
+	rejectedTooNew  = "too_new"
+	rejectedExpired = "expired"
+)
+
+// rejectedEvent is written to the rejected events file as a line of JSON.
+type rejectedEvent struct {
+	LogGroup  string `json:"log_group"`
+	LogStream string `json:"log_stream"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// rejectedEvents picks up the events of batch which are reported in info.
+// The end indexes are exclusive, i.e. batch[:TooOldLogEventEndIndex] are
+// too old and batch[TooNewLogEventStartIndex:] are too new.
+func rejectedEvents(token UpdateToken, batch []*cloudwatchlogs.InputLogEvent, info *cloudwatchlogs.RejectedLogEventsInfo) []rejectedEvent {
+	reasons := make([]string, len(batch))
+	markRange := func(from, to int64, reason string) {
+		if from < 0 {
+			from = 0
+		}
+		if to > int64(len(batch)) {
+			to = int64(len(batch))
+		}
+		for i := from; i < to; i++ {
+			reasons[i] = reason
+		}
+	}
+
+	if info.ExpiredLogEventEndIndex != nil {
+		markRange(0, *info.ExpiredLogEventEndIndex, rejectedExpired)
+	}
+	if info.TooOldLogEventEndIndex != nil {
+		markRange(0, *info.TooOldLogEventEndIndex, rejectedTooOld)
+	}
+	if info.TooNewLogEventStartIndex != nil {
+		markRange(*info.TooNewLogEventStartIndex, int64(len(batch)), rejectedTooNew)
+	}
+
+	var rejected []rejectedEvent
+	for i, reason := range reasons {
+		if reason == "" {
+			continue
+		}
+		rejected = append(rejected, rejectedEvent{
+			LogGroup:  token.LogGroup,
+			LogStream: token.LogStream,
+			Reason:    reason,
+			Timestamp: *batch[i].Timestamp,
+			Message:   *batch[i].Message,
+		})
+	}
+	return rejected
+}
+
+// handleRejectedEvents logs the rejected events, and appends them to
+// the rejected events file when it is configured.
+func handleRejectedEvents(path string, rejected []rejectedEvent) {
+	for _, e := range rejected {
+		fmt.Printf("Rejected Event (%s) in %s/%s at %s: %s\n", e.Reason, e.LogGroup, e.LogStream,
+			time.Unix(0, e.Timestamp*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), e.Message)
+	}
+
+	if path == "" || len(rejected) == 0 {
+		return
+	}
+	if err := writeRejectedEvents(path, rejected); err != nil {
+		fmt.Printf("Failed to write rejected events to %s. error: %v\n", path, err)
+	}
+}
+
+func writeRejectedEvents(path string, rejected []rejectedEvent) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, e := range rejected {
+		line, err := jsoniter.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
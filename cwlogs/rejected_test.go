@@ -0,0 +1,50 @@
+package cwlogs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejectedEvents(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	batch := []*cloudwatchlogs.InputLogEvent{
+		newTestEvent("expired", ts),
+		newTestEvent("old", ts.Add(time.Second)),
+		newTestEvent("accepted", ts.Add(2*time.Second)),
+		newTestEvent("new", ts.Add(3*time.Second)),
+	}
+	info := &cloudwatchlogs.RejectedLogEventsInfo{
+		ExpiredLogEventEndIndex:  aws.Int64(1),
+		TooOldLogEventEndIndex:   aws.Int64(2),
+		TooNewLogEventStartIndex: aws.Int64(3),
+	}
+
+	rejected := rejectedEvents(UpdateToken{"group", "stream"}, batch, info)
+	assert.Len(t, rejected, 3)
+	assert.Equal(t, rejectedEvent{
+		LogGroup:  "group",
+		LogStream: "stream",
+		Reason:    rejectedTooOld,
+		Timestamp: aws.TimeUnixMilli(ts.Add(time.Second)),
+		Message:   "old",
+	}, rejected[1])
+	assert.Equal(t, "new", rejected[2].Message)
+	assert.Equal(t, rejectedTooNew, rejected[2].Reason)
+}
+
+func TestRejectedEventsOutOfRange(t *testing.T) {
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	batch := []*cloudwatchlogs.InputLogEvent{newTestEvent("message", ts)}
+	info := &cloudwatchlogs.RejectedLogEventsInfo{
+		TooOldLogEventEndIndex:   aws.Int64(5),
+		TooNewLogEventStartIndex: aws.Int64(5),
+	}
+
+	rejected := rejectedEvents(UpdateToken{"group", "stream"}, batch, info)
+	assert.Len(t, rejected, 1)
+	assert.Equal(t, rejectedTooOld, rejected[0].Reason)
+}
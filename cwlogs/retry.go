@@ -0,0 +1,134 @@
+package cwlogs
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// retryAction is how a failed PutLogEvents call is handled.
+type retryAction int
+
+const (
+	// retryBackoff retries the batch in the plugin with exponential
+	// backoff, and then returns FLB_RETRY when it keeps failing.
+	retryBackoff retryAction = iota
+	// retryLater returns FLB_RETRY, so that Fluent Bit retries the chunk.
+	retryLater
+	// retryDrop drops the batch, and returns FLB_ERROR.
+	retryDrop
+)
+
+var retryActionNames = map[string]retryAction{
+	"backoff": retryBackoff,
+	"retry":   retryLater,
+	"drop":    retryDrop,
+}
+
+const (
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseDelay   = 200 * time.Millisecond
+	defaultRetryMaxDelay    = 10 * time.Second
+)
+
+// defaultRetryActions classifies AWS error codes. The errors which are not
+// listed here, including network errors, are retried by Fluent Bit.
+var defaultRetryActions = map[string]retryAction{
+	// Transient errors on the service side.
+	"ThrottlingException":  retryBackoff,
+	"Throttling":           retryBackoff,
+	"RequestLimitExceeded": retryBackoff,
+	cloudwatchlogs.ErrCodeServiceUnavailableException: retryBackoff,
+	"ServiceUnavailable":                              retryBackoff,
+	"InternalFailure":                                 retryBackoff,
+	cloudwatchlogs.ErrCodeLimitExceededException:      retryBackoff,
+	cloudwatchlogs.ErrCodeOperationAbortedException:   retryBackoff,
+	// The batch itself is invalid, and sending it again never succeeds.
+	cloudwatchlogs.ErrCodeInvalidParameterException: retryDrop,
+	"SerializationException":                        retryDrop,
+	"ValidationException":                           retryDrop,
+	// Permissions and credentials may be fixed without restarting
+	// Fluent Bit, so that the chunk is kept.
+	"AccessDeniedException":                           retryLater,
+	cloudwatchlogs.ErrCodeUnrecognizedClientException: retryLater,
+	"ExpiredTokenException":                           retryLater,
+	// The logGroup or the logStream may be created again.
+	cloudwatchlogs.ErrCodeResourceNotFoundException: retryLater,
+}
+
+// sleep is replaced in tests.
+var sleep = time.Sleep
+
+type RetryConf struct {
+	actions     map[string]retryAction
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// GetRetryConfig parses RetryPolicy, which overrides the action of error
+// codes, e.g. "AccessDeniedException=drop,ThrottlingException=retry".
+func GetRetryConfig(policy, maxAttempts, baseDelay, maxDelay string) (*RetryConf, error) {
+	conf := &RetryConf{actions: make(map[string]retryAction)}
+	for code, action := range defaultRetryActions {
+		conf.actions[code] = action
+	}
+
+	for _, entry := range strings.Split(policy, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("Invalid RetryPolicy entry: %s", entry)
+		}
+		action, ok := retryActionNames[strings.ToLower(strings.TrimSpace(kv[1]))]
+		if !ok {
+			return nil, fmt.Errorf("Unknown action in RetryPolicy: %s", entry)
+		}
+		conf.actions[strings.TrimSpace(kv[0])] = action
+	}
+
+	var err error
+	if conf.maxAttempts, err = ParseIntParameter("RetryMaxAttempts", maxAttempts, defaultRetryMaxAttempts); err != nil {
+		return nil, err
+	}
+	if conf.baseDelay, err = ParseDurationParameter("RetryBaseDelay", baseDelay, defaultRetryBaseDelay); err != nil {
+		return nil, err
+	}
+	if conf.maxDelay, err = ParseDurationParameter("RetryMaxDelay", maxDelay, defaultRetryMaxDelay); err != nil {
+		return nil, err
+	}
+	if conf.maxDelay < conf.baseDelay {
+		return nil, fmt.Errorf("RetryMaxDelay must not be shorter than RetryBaseDelay")
+	}
+
+	return conf, nil
+}
+
+// action returns how err is handled.
+func (c *RetryConf) action(err error) retryAction {
+	if awsErr, ok := err.(awserr.Error); ok {
+		if action, ok := c.actions[awsErr.Code()]; ok {
+			return action
+		}
+	}
+	return retryLater
+}
+
+// Backoff returns the delay before the nth retry, which is chosen at
+// random up to the exponentially growing limit ("full jitter").
+func (c *RetryConf) Backoff(n int) time.Duration {
+	limit := c.maxDelay
+	if n < 32 {
+		if d := c.baseDelay << uint(n-1); d > 0 && d < limit {
+			limit = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
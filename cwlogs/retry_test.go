@@ -0,0 +1,60 @@
+package cwlogs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRetryConfig(t *testing.T) {
+	conf, err := GetRetryConfig("", "", "", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, defaultRetryMaxAttempts, conf.maxAttempts)
+	assert.Equal(t, defaultRetryBaseDelay, conf.baseDelay)
+	assert.Equal(t, defaultRetryMaxDelay, conf.maxDelay)
+
+	conf, err = GetRetryConfig("AccessDeniedException=drop, ThrottlingException = retry,CustomException=Backoff", "3", "1s", "30s")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, retryDrop, conf.actions["AccessDeniedException"])
+	assert.Equal(t, retryLater, conf.actions["ThrottlingException"])
+	assert.Equal(t, retryBackoff, conf.actions["CustomException"])
+	assert.Equal(t, 3, conf.maxAttempts)
+	// The defaults are not modified.
+	assert.Equal(t, retryBackoff, defaultRetryActions["ThrottlingException"])
+
+	for _, policy := range []string{"ThrottlingException", "=drop", "ThrottlingException=ignore"} {
+		_, err := GetRetryConfig(policy, "", "", "")
+		assert.Error(t, err, policy)
+	}
+	_, err = GetRetryConfig("", "", "10s", "1s")
+	assert.Error(t, err)
+}
+
+func TestRetryAction(t *testing.T) {
+	conf, _ := GetRetryConfig("", "", "", "")
+	assert.Equal(t, retryBackoff, conf.action(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.Equal(t, retryBackoff, conf.action(awserr.New(cloudwatchlogs.ErrCodeServiceUnavailableException, "", nil)))
+	assert.Equal(t, retryDrop, conf.action(awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "", nil)))
+	assert.Equal(t, retryLater, conf.action(awserr.New("AccessDeniedException", "", nil)))
+	assert.Equal(t, retryLater, conf.action(awserr.New("UnknownException", "", nil)))
+	assert.Equal(t, retryLater, conf.action(errors.New("connection reset by peer")))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	conf := &RetryConf{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	for i := 0; i < 100; i++ {
+		assert.True(t, conf.Backoff(1) <= 100*time.Millisecond)
+		assert.True(t, conf.Backoff(3) <= 400*time.Millisecond)
+		assert.True(t, conf.Backoff(10) <= time.Second)
+		assert.True(t, conf.Backoff(100) <= time.Second)
+		assert.True(t, conf.Backoff(100) >= 0)
+	}
+}
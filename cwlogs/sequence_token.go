@@ -0,0 +1,30 @@
+package cwlogs
+
+import (
+	"regexp"
+)
+
+// maxSequenceTokenAttempts is the number of PutLogEvents attempts for
+// a batch when the sequence token turns out to be stale.
+const maxSequenceTokenAttempts = 3
+
+// The messages of InvalidSequenceTokenException and
+// DataAlreadyAcceptedException carry the next sequence token:
+//
+//	The given sequenceToken is invalid. The next expected sequenceToken is: 4959...
+//	The given batch of log events has already been accepted. The next batch can be sent with sequenceToken: 4959...
+var expectedSequenceTokenPattern = regexp.MustCompile(`sequenceToken(?: is)?: (\S+)`)
+
+// expectedSequenceToken extracts the next sequence token from an error
+// message. A logStream which has no events expects "null", which means
+// that no sequence token should be sent.
+func expectedSequenceToken(message string) (string, bool) {
+	m := expectedSequenceTokenPattern.FindStringSubmatch(message)
+	if m == nil {
+		return "", false
+	}
+	if m[1] == "null" {
+		return "", true
+	}
+	return m[1], true
+}
@@ -0,0 +1,24 @@
+package cwlogs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectedSequenceToken(t *testing.T) {
+	token, found := expectedSequenceToken("The given sequenceToken is invalid. The next expected sequenceToken is: 49590302128290341285829461929385925113476325553063493634")
+	assert.True(t, found)
+	assert.Equal(t, "49590302128290341285829461929385925113476325553063493634", token)
+
+	token, found = expectedSequenceToken("The given batch of log events has already been accepted. The next batch can be sent with sequenceToken: 49590302128290341285829461929385925113476325553063493635")
+	assert.True(t, found)
+	assert.Equal(t, "49590302128290341285829461929385925113476325553063493635", token)
+
+	token, found = expectedSequenceToken("The given sequenceToken is invalid. The next expected sequenceToken is: null")
+	assert.True(t, found)
+	assert.Equal(t, "", token)
+
+	_, found = expectedSequenceToken("Rate exceeded")
+	assert.False(t, found)
+}
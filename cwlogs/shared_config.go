@@ -0,0 +1,108 @@
+package cwlogs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// sharedConfigFiles returns the shared config file and the shared
+// credentials file, which is Credential when it is specified. The values
+// in the latter take precedence, as the AWS CLI does.
+func sharedConfigFiles(opts *CredentialOptions) []string {
+	credentialsFile := opts.Credential
+	if credentialsFile == "" {
+		credentialsFile = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if credentialsFile == "" {
+		credentialsFile = defaults.SharedCredentialsFilename()
+	}
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = defaults.SharedConfigFilename()
+	}
+	return []string{configFile, credentialsFile}
+}
+
+// sharedConfigSession loads the profile, which is Profile or AWS_PROFILE,
+// from the shared config files with the SDK. The roles of the profile are
+// assumed with the STS endpoint of Endpoints.
+func sharedConfigSession(opts *CredentialOptions) (*session.Session, error) {
+	config := opts.Endpoints.Config(ServiceSTS, opts.Region)
+	if opts.EC2MetadataEndpoint != "" {
+		// For credential_source = Ec2InstanceMetadata
+		config.EndpointResolver = endpoints.ResolverFunc(func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+			if service == endpoints.Ec2metadataServiceID {
+				return endpoints.ResolvedEndpoint{URL: opts.EC2MetadataEndpoint}, nil
+			}
+			return endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
+		})
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           opts.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+		SharedConfigFiles: sharedConfigFiles(opts),
+	})
+}
+
+// ProfileRegion returns the region of the profile when Credential or
+// Profile is specified, or "" otherwise.
+func ProfileRegion(opts *CredentialOptions) string {
+	if opts.Credential == "" && opts.Profile == "" {
+		return ""
+	}
+	sess, err := sharedConfigSession(opts)
+	if err != nil {
+		return ""
+	}
+	return aws.StringValue(sess.Config.Region)
+}
+
+// sharedConfigProvider provides the credentials of the profile, which the
+// SDK resolves from the keys of the profile, role_arn with source_profile or
+// credential_source, or credential_process.
+type sharedConfigProvider struct {
+	profile string
+	creds   *credentials.Credentials
+}
+
+func newSharedConfigProvider(opts *CredentialOptions) (*sharedConfigProvider, error) {
+	sess, err := sharedConfigSession(opts)
+	if err != nil {
+		return nil, err
+	}
+	profile := opts.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = session.DefaultSharedConfigProfile
+	}
+	return &sharedConfigProvider{profile: profile, creds: sess.Config.Credentials}, nil
+}
+
+// Retrieve rejects the EC2 instance role, to which the SDK falls back when
+// the profile has no credentials, so that the ec2 provider of
+// CredentialChain decides whether it is used.
+func (p *sharedConfigProvider) Retrieve() (credentials.Value, error) {
+	value, err := p.creds.Get()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if value.ProviderName == ec2rolecreds.ProviderName {
+		return credentials.Value{}, fmt.Errorf("Profile %s has no credentials", p.profile)
+	}
+	return value, nil
+}
+
+func (p *sharedConfigProvider) IsExpired() bool {
+	return p.creds.IsExpired()
+}
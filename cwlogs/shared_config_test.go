@@ -0,0 +1,179 @@
+package cwlogs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sharedCredentialsFile = `[default]
+aws_access_key_id = DEFAULTAKID
+aws_secret_access_key = DEFAULTSECRET
+
+# A profile of another account
+[production] ; with a comment
+aws_access_key_id = PRODUCTIONAKID
+aws_secret_access_key = PRODUCTIONSECRET
+aws_session_token = PRODUCTIONTOKEN
+`
+
+const sharedConfigFile = `[default]
+region = us-east-1
+
+[profile production]
+region = ap-northeast-1
+
+[profile logging]
+role_arn = arn:aws:iam::123456789012:role/logging
+source_profile = production
+external_id = exampleexternalid
+region = eu-west-1
+
+[profile central]
+role_arn = arn:aws:iam::210987654321:role/central
+source_profile = logging
+role_session_name = central-logging
+
+[profile loop]
+role_arn = arn:aws:iam::123456789012:role/loop
+source_profile = loop2
+
+[profile loop2]
+role_arn = arn:aws:iam::123456789012:role/loop2
+source_profile = loop
+
+[profile nocreds]
+region = us-west-2
+
+[profile environment]
+role_arn = arn:aws:iam::123456789012:role/environment
+credential_source = Environment
+`
+
+// withSharedConfig writes the shared credentials file and the shared config
+// file, and points the environment variables to them.
+func withSharedConfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sharedconfig")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	ioutil.WriteFile(credentialsFile, []byte(sharedCredentialsFile), 0600)
+	ioutil.WriteFile(configFile, []byte(sharedConfigFile), 0600)
+
+	env := map[string]string{}
+	for key, value := range noEnvCredentials {
+		env[key] = value
+	}
+	env["AWS_CONFIG_FILE"] = configFile
+	env["AWS_REGION"] = ""
+	env["AWS_DEFAULT_REGION"] = ""
+	// A profile without credentials does not wait for the EC2 instance
+	// metadata, to which the SDK falls back.
+	env["AWS_EC2_METADATA_DISABLED"] = "true"
+	restore := setenv(env)
+	return credentialsFile, func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSharedCredentialsWithProfile(t *testing.T) {
+	credentialsFile, cleanup := withSharedConfig(t)
+	defer cleanup()
+
+	opts := &CredentialOptions{Credential: credentialsFile, CredentialChain: []string{"shared"}}
+	creds, err := GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ := creds.Get()
+	assert.Equal(t, "DEFAULTAKID", value.AccessKeyID)
+
+	opts.Profile = "production"
+	creds, err = GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ = creds.Get()
+	assert.Equal(t, "PRODUCTIONAKID", value.AccessKeyID)
+	assert.Equal(t, "PRODUCTIONTOKEN", value.SessionToken)
+
+	for _, profile := range []string{"unknown", "nocreds", "loop"} {
+		opts.Profile = profile
+		_, err = GetCredentials(opts)
+		assert.Error(t, err, profile)
+	}
+}
+
+func TestProfileRegion(t *testing.T) {
+	credentialsFile, cleanup := withSharedConfig(t)
+	defer cleanup()
+
+	assert.Equal(t, "ap-northeast-1", ProfileRegion(&CredentialOptions{Credential: credentialsFile, Profile: "production"}))
+	assert.Equal(t, "", ProfileRegion(&CredentialOptions{Credential: credentialsFile, Profile: "unknown"}))
+	// The shared config is not read without Credential and Profile.
+	assert.Equal(t, "", ProfileRegion(&CredentialOptions{}))
+}
+
+func TestSharedConfigAssumeRole(t *testing.T) {
+	credentialsFile, cleanup := withSharedConfig(t)
+	defer cleanup()
+
+	var roles, externalIDs, authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		roles = append(roles, r.PostForm.Get("RoleArn"))
+		externalIDs = append(externalIDs, r.PostForm.Get("ExternalId"))
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		fmt.Fprintf(w, assumeRoleResponse, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	opts := &CredentialOptions{
+		Credential:      credentialsFile,
+		Profile:         "logging",
+		Region:          "us-east-1",
+		Endpoints:       &EndpointConf{stsEndpoint: server.URL},
+		CredentialChain: []string{"shared"},
+	}
+	creds, err := GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ := creds.Get()
+	assert.Equal(t, "ASSUMEDAKID", value.AccessKeyID)
+
+	// production assumes logging.
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/logging"}, roles)
+	assert.Equal(t, []string{"exampleexternalid"}, externalIDs)
+	assert.Contains(t, authorizations[0], "Credential=PRODUCTIONAKID/")
+	assert.Contains(t, authorizations[0], "/us-east-1/sts/")
+
+	// The SDK assumes only one role, so source_profile needs keys.
+	opts.Profile = "central"
+	_, err = GetCredentials(opts)
+	assert.Error(t, err)
+}
+
+func TestSharedConfigCredentialSource(t *testing.T) {
+	credentialsFile, cleanup := withSharedConfig(t)
+	defer cleanup()
+	defer setenv(map[string]string{"AWS_ACCESS_KEY_ID": "ENVAKID", "AWS_SECRET_ACCESS_KEY": "ENVSECRET"})()
+
+	opts := &CredentialOptions{Credential: credentialsFile, Profile: "environment", CredentialChain: []string{"shared"}}
+	creds, err := GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	value, _ := creds.Get()
+	assert.Equal(t, "ENVAKID", value.AccessKeyID)
+}
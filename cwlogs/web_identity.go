@@ -0,0 +1,78 @@
+package cwlogs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const webIdentityProviderName = "WebIdentityCredentialsProvider"
+
+// webIdentityProvider retrieves credentials with AssumeRoleWithWebIdentity,
+// as IAM Roles for Service Accounts on EKS. The token file is read again on
+// every refresh, because it is rotated by kubelet.
+type webIdentityProvider struct {
+	credentials.Expiry
+
+	client          *sts.STS
+	roleARN         string
+	roleSessionName string
+	tokenFile       string
+}
+
+// webIdentityCredentialProvider returns the web identity provider which is
+// configured with AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE, or nil
+// when they are not set.
+func webIdentityCredentialProvider(opts *CredentialOptions) credentials.Provider {
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return nil
+	}
+
+	roleSessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if roleSessionName == "" {
+		roleSessionName = defaultRoleSessionName
+	}
+
+	config := opts.Endpoints.Config(ServiceSTS, opts.Region)
+	config.Credentials = credentials.AnonymousCredentials
+
+	return &webIdentityProvider{
+		client:          sts.New(session.New(config)),
+		roleARN:         roleARN,
+		roleSessionName: roleSessionName,
+		tokenFile:       tokenFile,
+	}
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName},
+			fmt.Errorf("Failed to read web identity token file: %v", err)
+	}
+
+	resp, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, err
+	}
+
+	p.SetExpiration(*resp.Credentials.Expiration, remoteCredentialsExpiryWindow)
+
+	return credentials.Value{
+		AccessKeyID:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+		ProviderName:    webIdentityProviderName,
+	}, nil
+}
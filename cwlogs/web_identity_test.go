@@ -0,0 +1,94 @@
+package cwlogs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const assumeRoleWithWebIdentityResponse = `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>WEBIDENTITYAKID</AccessKeyId>
+      <SecretAccessKey>WEBIDENTITYSECRET</SecretAccessKey>
+      <SessionToken>WEBIDENTITYTOKEN</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <SubjectFromWebIdentityToken>system:serviceaccount:logging:fluent-bit</SubjectFromWebIdentityToken>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/logging/fluent-bit</Arn>
+      <AssumedRoleId>AROA:fluent-bit</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleWithWebIdentityResult>
+  <ResponseMetadata>
+    <RequestId>ad4156e9-bce1-11e2-82e6-6b6efEXAMPLE</RequestId>
+  </ResponseMetadata>
+</AssumeRoleWithWebIdentityResponse>`
+
+func TestWebIdentityCredentialProviderNotConfigured(t *testing.T) {
+	defer setenv(noEnvCredentials)()
+	assert.Nil(t, webIdentityCredentialProvider(&CredentialOptions{Region: "us-east-1"}))
+}
+
+func TestWebIdentityCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webidentity")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	ioutil.WriteFile(tokenFile, []byte("firsttoken"), 0600)
+
+	var tokens []string
+	expiration := time.Now().Add(time.Minute)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "AssumeRoleWithWebIdentity", r.PostForm.Get("Action"))
+		assert.Equal(t, "arn:aws:iam::123456789012:role/logging", r.PostForm.Get("RoleArn"))
+		assert.Empty(t, r.Header.Get("Authorization"), "AssumeRoleWithWebIdentity is not signed")
+		tokens = append(tokens, r.PostForm.Get("WebIdentityToken"))
+		fmt.Fprintf(w, assumeRoleWithWebIdentityResponse, expiration.UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	env := map[string]string{}
+	for key, value := range noEnvCredentials {
+		env[key] = value
+	}
+	env["AWS_ROLE_ARN"] = "arn:aws:iam::123456789012:role/logging"
+	env["AWS_WEB_IDENTITY_TOKEN_FILE"] = tokenFile
+	defer setenv(env)()
+
+	opts := &CredentialOptions{
+		Region:          "us-east-1",
+		Endpoints:       &EndpointConf{stsEndpoint: server.URL},
+		CredentialChain: []string{"webidentity"},
+	}
+	creds, err := GetCredentials(opts)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, []string{"firsttoken"}, tokens)
+
+	// The credentials expire within the expiry window, and the rotated
+	// token is read on refresh.
+	ioutil.WriteFile(tokenFile, []byte("rotatedtoken"), 0600)
+	expiration = time.Now().Add(time.Hour)
+	assert.True(t, creds.IsExpired())
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, "WEBIDENTITYAKID", value.AccessKeyID)
+	assert.Equal(t, "WEBIDENTITYTOKEN", value.SessionToken)
+	assert.Equal(t, webIdentityProviderName, value.ProviderName)
+	assert.Equal(t, []string{"firsttoken", "rotatedtoken"}, tokens)
+	assert.False(t, creds.IsExpired())
+}
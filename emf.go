@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/json-iterator/go"
+)
+
+// Embedded Metric Format limits.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+const (
+	maxEMFMetrics       = 100
+	maxEMFDimensions    = 30
+	maxEMFValues        = 100
+	maxEMFNamespaceSize = 255
+)
+
+// emfUnits maps the lower case units of CloudWatch metrics to their names.
+var emfUnits = make(map[string]string)
+
+func init() {
+	for _, unit := range []string{
+		"Seconds", "Microseconds", "Milliseconds",
+		"Bytes", "Kilobytes", "Megabytes", "Gigabytes", "Terabytes",
+		"Bits", "Kilobits", "Megabits", "Gigabits", "Terabits",
+		"Percent", "Count",
+		"Bytes/Second", "Kilobytes/Second", "Megabytes/Second", "Gigabytes/Second", "Terabytes/Second",
+		"Bits/Second", "Kilobits/Second", "Megabits/Second", "Gigabits/Second", "Terabits/Second",
+		"Count/Second", "None",
+	} {
+		emfUnits[strings.ToLower(unit)] = unit
+	}
+}
+
+// emfFormatter renders a record as a CloudWatch Embedded Metric Format
+// document, from which CloudWatch Logs extracts the metrics. The fields
+// which are neither metrics nor dimensions are kept as properties.
+type emfFormatter struct {
+	namespace  string
+	dimensions [][]string
+	metrics    []emfMetric
+}
+
+type emfMetric struct {
+	name string
+	unit string
+}
+
+// getEMFFormatter parses MetricDimensions, dimension sets separated by ';'
+// whose dimensions are separated by ',', e.g. "service,operation;service",
+// and MetricKeys, metrics separated by ',' with optional units, e.g.
+// "latency:Milliseconds,requests:Count".
+func getEMFFormatter(namespace, dimensions, metricKeys string) (*emfFormatter, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("MetricNamespace is required with Format emf")
+	}
+	if len(namespace) > maxEMFNamespaceSize {
+		return nil, fmt.Errorf("Invalid MetricNamespace: %s (must be at most %d characters)", namespace, maxEMFNamespaceSize)
+	}
+	if metricKeys == "" {
+		return nil, fmt.Errorf("MetricKeys is required with Format emf")
+	}
+	f := &emfFormatter{namespace: namespace}
+
+	metrics := make(map[string]bool)
+	for _, key := range strings.Split(metricKeys, ",") {
+		metric := emfMetric{name: strings.TrimSpace(key)}
+		if i := strings.LastIndexByte(metric.name, ':'); i >= 0 {
+			unit, ok := emfUnits[strings.ToLower(strings.TrimSpace(metric.name[i+1:]))]
+			if !ok {
+				return nil, fmt.Errorf("Invalid MetricKeys: unknown unit in %s", key)
+			}
+			metric.name, metric.unit = strings.TrimSpace(metric.name[:i]), unit
+		}
+		if metric.name == "" || metrics[metric.name] {
+			return nil, fmt.Errorf("Invalid MetricKeys: %s", metricKeys)
+		}
+		metrics[metric.name] = true
+		f.metrics = append(f.metrics, metric)
+	}
+	if len(f.metrics) > maxEMFMetrics {
+		return nil, fmt.Errorf("Invalid MetricKeys: %d metrics exceed the limit of %d", len(f.metrics), maxEMFMetrics)
+	}
+
+	if dimensions == "" {
+		// An empty dimension set publishes the metrics without dimensions.
+		f.dimensions = [][]string{{}}
+		return f, nil
+	}
+	for _, set := range strings.Split(dimensions, ";") {
+		var dimensionSet []string
+		seen := make(map[string]bool)
+		for _, name := range strings.Split(set, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] || metrics[name] {
+				return nil, fmt.Errorf("Invalid MetricDimensions: %s", dimensions)
+			}
+			seen[name] = true
+			dimensionSet = append(dimensionSet, name)
+		}
+		if len(dimensionSet) > maxEMFDimensions {
+			return nil, fmt.Errorf("Invalid MetricDimensions: %d dimensions in a set exceed the limit of %d", len(dimensionSet), maxEMFDimensions)
+		}
+		f.dimensions = append(f.dimensions, dimensionSet)
+	}
+	return f, nil
+}
+
+// format returns an error when a dimension is missing or empty, or when
+// none of the metrics is a number. Metrics which are not numbers are kept
+// as properties.
+func (f *emfFormatter) format(record map[interface{}]interface{}, tag string, ts time.Time, metadata map[interface{}]interface{}) (string, error) {
+	doc := toJSONMap(record)
+	for _, set := range f.dimensions {
+		for _, name := range set {
+			value := fieldString(doc[name])
+			if value == "" {
+				return "", fmt.Errorf("dimension %s is missing", name)
+			}
+			doc[name] = value
+		}
+	}
+
+	var metrics []map[string]string
+	for _, metric := range f.metrics {
+		value, ok := emfValue(doc[metric.name])
+		if !ok {
+			continue
+		}
+		doc[metric.name] = value
+		definition := map[string]string{"Name": metric.name}
+		if metric.unit != "" {
+			definition["Unit"] = metric.unit
+		}
+		metrics = append(metrics, definition)
+	}
+	if len(metrics) == 0 {
+		return "", fmt.Errorf("none of MetricKeys is a number")
+	}
+
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": aws.TimeUnixMilli(ts),
+		"CloudWatchMetrics": []interface{}{
+			map[string]interface{}{
+				"Namespace":  f.namespace,
+				"Dimensions": f.dimensions,
+				"Metrics":    metrics,
+			},
+		},
+	}
+	return jsoniter.MarshalToString(doc)
+}
+
+// emfValue returns the value of a metric, which is a number or an array
+// of up to 100 numbers. Strings of numbers are converted to numbers.
+func emfValue(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return t, true
+	case float32:
+		return t, !math.IsNaN(float64(t)) && !math.IsInf(float64(t), 0)
+	case float64:
+		return t, !math.IsNaN(t) && !math.IsInf(t, 0)
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		return f, true
+	case []interface{}:
+		if len(t) == 0 || len(t) > maxEMFValues {
+			return nil, false
+		}
+		values := make([]interface{}, len(t))
+		for i, e := range t {
+			value, ok := emfValue(e)
+			if !ok {
+				return nil, false
+			}
+			if _, nested := value.([]interface{}); nested {
+				return nil, false
+			}
+			values[i] = value
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEMFFormatter(t *testing.T) {
+	f, err := getFormatter("emf", "", "MyApp", "service, operation;service", "latency:Milliseconds, size:bytes/second,requests")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, &emfFormatter{
+		namespace:  "MyApp",
+		dimensions: [][]string{{"service", "operation"}, {"service"}},
+		metrics: []emfMetric{
+			{name: "latency", unit: "Milliseconds"},
+			{name: "size", unit: "Bytes/Second"},
+			{name: "requests"},
+		},
+	}, f)
+
+	f, err = getFormatter("EMF", "", "MyApp", "", "latency")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, [][]string{{}}, f.(*emfFormatter).dimensions)
+
+	var keys, dimensions []string
+	for i := 0; i <= maxEMFMetrics; i++ {
+		keys = append(keys, fmt.Sprintf("m%d", i))
+	}
+	for i := 0; i <= maxEMFDimensions; i++ {
+		dimensions = append(dimensions, fmt.Sprintf("d%d", i))
+	}
+	for _, tc := range [][4]string{
+		{"json", "MyApp", "", ""},
+		{"template", "", "", "latency"},
+		{"emf", "", "", "latency"},
+		{"emf", strings.Repeat("n", maxEMFNamespaceSize+1), "", "latency"},
+		{"emf", "MyApp", "service", ""},
+		{"emf", "MyApp", "", "latency:Hours"},
+		{"emf", "MyApp", "", "latency,latency"},
+		{"emf", "MyApp", "", "latency,,size"},
+		{"emf", "MyApp", "", strings.Join(keys, ",")},
+		{"emf", "MyApp", strings.Join(dimensions, ","), "latency"},
+		{"emf", "MyApp", "service,service", "latency"},
+		{"emf", "MyApp", "service;", "latency"},
+		{"emf", "MyApp", "latency", "latency"},
+	} {
+		_, err = getFormatter(tc[0], "{{.msg}}", tc[1], tc[2], tc[3])
+		assert.Error(t, err, "%v", tc)
+	}
+}
+
+func TestEMFFormatter(t *testing.T) {
+	f, err := getEMFFormatter("MyApp", "service,operation", "latency:Milliseconds,size:Bytes,requests:Count")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 345000000, time.UTC)
+	record := map[interface{}]interface{}{
+		"service":   []byte("web"),
+		"operation": 1,
+		"latency":   []interface{}{12.5, int64(3)},
+		"size":      []byte("512"),
+		"requests":  "many",
+		"path":      "/index.html",
+		"_aws":      "overwritten",
+	}
+
+	message, err := f.format(record, "app.web", ts, nil)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &doc); err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"Timestamp": float64(1552212672345),
+		"CloudWatchMetrics": []interface{}{
+			map[string]interface{}{
+				"Namespace":  "MyApp",
+				"Dimensions": []interface{}{[]interface{}{"service", "operation"}},
+				"Metrics": []interface{}{
+					map[string]interface{}{"Name": "latency", "Unit": "Milliseconds"},
+					map[string]interface{}{"Name": "size", "Unit": "Bytes"},
+				},
+			},
+		},
+	}, doc["_aws"])
+	assert.Equal(t, "web", doc["service"])
+	assert.Equal(t, "1", doc["operation"])
+	assert.Equal(t, []interface{}{12.5, float64(3)}, doc["latency"])
+	assert.Equal(t, float64(512), doc["size"])
+	// Metrics which are not numbers and other fields are kept as properties.
+	assert.Equal(t, "many", doc["requests"])
+	assert.Equal(t, "/index.html", doc["path"])
+
+	_, err = f.format(map[interface{}]interface{}{"service": "web", "latency": 1}, "", ts, nil)
+	assert.Error(t, err)
+	_, err = f.format(map[interface{}]interface{}{"service": "web", "operation": "get", "requests": "many"}, "", ts, nil)
+	assert.Error(t, err)
+}
+
+func TestPluginShipsEMFDocuments(t *testing.T) {
+	fake := newFakeCloudWatchLogs()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "us-east-1",
+		autoCreateStream: "true",
+		params: map[string]string{
+			"Endpoint":         server.URL,
+			"Format":           "emf",
+			"MetricNamespace":  "MyApp",
+			"MetricDimensions": "service",
+			"MetricKeys":       "latency:Milliseconds",
+		},
+	}
+	plugin = &endpointTestPlugin{testFluentPlugin: testplugin}
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"service": "web", "latency": 12})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, "exampletag"))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, []string{cwlogs.EMFLogFormat}, fake.logFormats)
+	assert.Len(t, fake.logStreams["examplegroup:examplestream"], 1)
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1552212672000,
+			"CloudWatchMetrics": [{
+				"Namespace": "MyApp",
+				"Dimensions": [["service"]],
+				"Metrics": [{"Name": "latency", "Unit": "Milliseconds"}]
+			}]
+		},
+		"service": "web",
+		"latency": 12
+	}`, fake.logStreams["examplegroup:examplestream"][0])
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCloudWatchLogs is an in-process CloudWatch Logs which keeps the
+// events put into each logStream.
+type fakeCloudWatchLogs struct {
+	mu             sync.Mutex
+	logGroups      map[string]bool
+	logStreams     map[string][]string
+	authorizations []string
+	// logFormats is the x-amzn-logs-format header of PutLogEvents calls.
+	logFormats []string
+}
+
+func newFakeCloudWatchLogs() *fakeCloudWatchLogs {
+	return &fakeCloudWatchLogs{
+		logGroups:  make(map[string]bool),
+		logStreams: make(map[string][]string),
+	}
+}
+
+func (f *fakeCloudWatchLogs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var req struct {
+		LogGroupName        string
+		LogGroupNamePrefix  string
+		LogStreamName       string
+		LogStreamNamePrefix string
+		LogEvents           []struct {
+			Message   string
+			Timestamp int64
+		}
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.authorizations = append(f.authorizations, r.Header.Get("Authorization"))
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	var resp interface{}
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.") {
+	case "DescribeLogGroups":
+		var logGroups []map[string]string
+		for name := range f.logGroups {
+			if strings.HasPrefix(name, req.LogGroupNamePrefix) {
+				logGroups = append(logGroups, map[string]string{"logGroupName": name})
+			}
+		}
+		resp = map[string]interface{}{"logGroups": logGroups}
+	case "CreateLogGroup":
+		f.logGroups[req.LogGroupName] = true
+		resp = struct{}{}
+	case "DescribeLogStreams":
+		var logStreams []map[string]string
+		for key := range f.logStreams {
+			name := strings.TrimPrefix(key, req.LogGroupName+":")
+			if name != key && strings.HasPrefix(name, req.LogStreamNamePrefix) {
+				logStreams = append(logStreams, map[string]string{"logStreamName": name})
+			}
+		}
+		resp = map[string]interface{}{"logStreams": logStreams}
+	case "CreateLogStream":
+		f.logStreams[req.LogGroupName+":"+req.LogStreamName] = []string{}
+		resp = struct{}{}
+	case "PutLogEvents":
+		key := req.LogGroupName + ":" + req.LogStreamName
+		f.logFormats = append(f.logFormats, r.Header.Get("x-amzn-logs-format"))
+		for _, event := range req.LogEvents {
+			f.logStreams[key] = append(f.logStreams[key], event.Message)
+		}
+		resp = map[string]string{"nextSequenceToken": "token"}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		resp = map[string]string{"__type": "UnknownOperationException"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// endpointTestPlugin calls the CloudWatch Logs API for real, while the
+// configuration and the records come from testFluentPlugin.
+type endpointTestPlugin struct {
+	*testFluentPlugin
+	api fluentPlugin
+}
+
+func (p *endpointTestPlugin) Put(client *cloudwatchlogs.CloudWatchLogs, logEvents []*cloudwatchlogs.InputLogEvent, logGroupName, logStreamName, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	return p.api.Put(client, logEvents, logGroupName, logStreamName, sequenceToken)
+}
+
+func (p *endpointTestPlugin) CheckLogGroupsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) bool {
+	return p.api.CheckLogGroupsExistence(client, logGroupName)
+}
+
+func (p *endpointTestPlugin) CheckLogStreamsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) (bool, string) {
+	return p.api.CheckLogStreamsExistence(client, logGroupName, logStreamName)
+}
+
+func (p *endpointTestPlugin) CreateLogGroup(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) error {
+	return p.api.CreateLogGroup(client, logGroupName)
+}
+
+func (p *endpointTestPlugin) CreateLogStream(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) error {
+	return p.api.CreateLogStream(client, logGroupName, logStreamName)
+}
+
+func TestPluginShipsRecordsToCustomEndpoint(t *testing.T) {
+	fake := newFakeCloudWatchLogs()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "us-east-1",
+		autoCreateStream: "true",
+		params:           map[string]string{"Endpoint": server.URL},
+	}
+	plugin = &endpointTestPlugin{testFluentPlugin: testplugin}
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"message": "first"})
+	testplugin.addrecord(0, output.FLBTime{Time: ts.Add(time.Second)}, map[interface{}]interface{}{"message": "second"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, "exampletag"))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.True(t, fake.logGroups["examplegroup"])
+	assert.Equal(t, []string{`{"message":"first"}`, `{"message":"second"}`}, fake.logStreams["examplegroup:examplestream"])
+	// The requests are signed for the region with the plugin credentials.
+	assert.NotEmpty(t, fake.authorizations)
+	for _, authorization := range fake.authorizations {
+		assert.Contains(t, authorization, "Credential=AKID/")
+		assert.Contains(t, authorization, "/us-east-1/logs/")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// eventTime decodes the timestamp of an event, which Fluent Bit encodes as
+// an EventTime extension, or integer or float seconds. Since Fluent Bit v2,
+// an event is [[timestamp, metadata], record], and the metadata map is
+// returned as well. It returns false when the timestamp is not known.
+func eventTime(ts interface{}) (time.Time, map[interface{}]interface{}, bool) {
+	if header, ok := ts.([]interface{}); ok {
+		if len(header) == 0 {
+			return time.Time{}, nil, false
+		}
+		var metadata map[interface{}]interface{}
+		if len(header) > 1 {
+			metadata, _ = header[1].(map[interface{}]interface{})
+		}
+		t, ok := eventTimestamp(header[0])
+		return t, metadata, ok
+	}
+	t, ok := eventTimestamp(ts)
+	return t, nil, ok
+}
+
+// eventTimestamp decodes the types which the msgpack decoder produces for
+// a timestamp.
+func eventTimestamp(ts interface{}) (time.Time, bool) {
+	switch t := ts.(type) {
+	case output.FLBTime:
+		return t.Time, true
+	case time.Time:
+		return t, true
+	case uint64:
+		if t > math.MaxInt64 {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(t), 0), true
+	case int64:
+		return time.Unix(t, 0), true
+	case float64:
+		return epochFloat(t, time.Second)
+	case float32:
+		return epochFloat(float64(t), time.Second)
+	default:
+		return time.Time{}, false
+	}
+}
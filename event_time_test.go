@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+// The events are encoded as Fluent Bit does. The record is {"msg": "hi"},
+// the metadata is {"stream": "stderr"}, and the time is
+// 2019-03-10T10:11:12.345Z.
+const (
+	fixtureRecord   = "81a36d7367a26869"
+	fixtureMetadata = "81a673747265616da6737464657272"
+)
+
+func TestEventTime(t *testing.T) {
+	expected := time.Date(2019, time.March, 10, 10, 11, 12, 345000000, time.UTC)
+	metadata := map[interface{}]interface{}{"stream": []byte("stderr")}
+
+	for _, tc := range []struct {
+		name     string
+		event    string
+		time     time.Time
+		metadata map[interface{}]interface{}
+	}{
+		{"EventTime", "92" + "d700" + "5c84e2c014904840" + fixtureRecord, expected, nil},
+		{"EventTime in ext 8", "92" + "c70800" + "5c84e2c014904840" + fixtureRecord, expected, nil},
+		{"uint32 seconds", "92" + "ce5c84e2c0" + fixtureRecord, expected.Truncate(time.Second), nil},
+		{"int64 seconds", "92" + "d3000000005c84e2c0" + fixtureRecord, expected.Truncate(time.Second), nil},
+		{"float64 seconds", "92" + "cb41d72138b016147b" + fixtureRecord, expected, nil},
+		{"v2 EventTime", "92" + "92d7005c84e2c014904840" + fixtureMetadata + fixtureRecord, expected, metadata},
+		{"v2 empty metadata", "92" + "92d7005c84e2c01490484080" + fixtureRecord, expected, map[interface{}]interface{}{}},
+		{"v2 float64 seconds", "92" + "92cb41d72138b016147b" + fixtureMetadata + fixtureRecord, expected, metadata},
+		{"v2 without metadata", "92" + "91ce5c84e2c0" + fixtureRecord, expected.Truncate(time.Second), nil},
+	} {
+		data, err := hex.DecodeString(tc.event)
+		if err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		dec := output.NewDecoder(unsafe.Pointer(&data[0]), len(data))
+		ret, ts, record := output.GetRecord(dec)
+		assert.Equal(t, 0, ret, tc.name)
+		assert.Equal(t, []byte("hi"), record["msg"], tc.name)
+
+		eventTs, eventMetadata, ok := eventTime(ts)
+		assert.True(t, ok, tc.name)
+		assert.True(t, tc.time.Equal(eventTs), "%s: %v", tc.name, eventTs)
+		assert.Equal(t, tc.metadata, eventMetadata, tc.name)
+	}
+
+	for _, ts := range []interface{}{
+		"2019-03-10T10:11:12Z",
+		nil,
+		uint64(1 << 63),
+		[]interface{}{},
+		[]interface{}{nil, map[interface{}]interface{}{}},
+	} {
+		_, _, ok := eventTime(ts)
+		assert.False(t, ok, "%#v", ts)
+	}
+}
+
+func TestPluginFlusherWithEventMetadata(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		logStreamTmpl:    "app-$_metadata['stream']",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		params:           map[string]string{"Format": "template", "FormatTemplate": "{{.Metadata.stream}}: {{.msg}}"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 345000000, time.UTC)
+	metadata := map[interface{}]interface{}{"stream": []byte("stderr")}
+	record := map[interface{}]interface{}{"msg": []byte("hi")}
+	testplugin.addrecord(0, []interface{}{output.FLBTime{Time: ts}, metadata}, record)
+	testplugin.addrecord(0, 1552212672.345, record)
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+
+	assert.Len(t, testplugin.events, 2)
+	byStream := make(map[string]*events)
+	for _, e := range testplugin.events {
+		byStream[e.logStreamName] = e
+		assert.Equal(t, aws.TimeUnixMilli(ts), e.timestamp)
+	}
+	assert.Equal(t, "stderr: hi", string(byStream["app-stderr"].data))
+	// Without metadata, the name falls back and the template cannot render.
+	assert.JSONEq(t, `{"msg":"hi"}`, string(byStream["examplestream"].data))
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+}
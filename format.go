@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/json-iterator/go"
+)
+
+// formatter renders a record as the message of an event.
+type formatter interface {
+	format(record map[interface{}]interface{}, tag string, ts time.Time, metadata map[interface{}]interface{}) (string, error)
+}
+
+// getFormatter returns the formatter of Format. tmpl is the Go template
+// of the template format, and namespace, dimensions and metricKeys are the
+// metric options of the emf format.
+func getFormatter(format, tmpl, namespace, dimensions, metricKeys string) (formatter, error) {
+	format = strings.ToLower(format)
+	if format != "emf" && (namespace != "" || dimensions != "" || metricKeys != "") {
+		return nil, fmt.Errorf("MetricNamespace, MetricDimensions and MetricKeys require Format emf")
+	}
+	switch format {
+	case "", "json":
+		return jsonFormatter{}, nil
+	case "logfmt":
+		return logfmtFormatter{}, nil
+	case "ltsv":
+		return ltsvFormatter{}, nil
+	case "template":
+		if tmpl == "" {
+			return nil, fmt.Errorf("FormatTemplate is required with Format template")
+		}
+		t, err := template.New("FormatTemplate").Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid FormatTemplate: %v", err)
+		}
+		return &templateFormatter{tmpl: t}, nil
+	case "emf":
+		return getEMFFormatter(namespace, dimensions, metricKeys)
+	default:
+		return nil, fmt.Errorf("Invalid Format: %s", format)
+	}
+}
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) format(record map[interface{}]interface{}, tag string, ts time.Time, metadata map[interface{}]interface{}) (string, error) {
+	return createJSON(record)
+}
+
+// logfmtFormatter renders key=value pairs sorted by key. Values with
+// spaces, quotes or '=' are quoted.
+type logfmtFormatter struct{}
+
+func (logfmtFormatter) format(record map[interface{}]interface{}, tag string, ts time.Time, metadata map[interface{}]interface{}) (string, error) {
+	var b strings.Builder
+	for i, field := range sortedFields(record) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(field.key)
+		b.WriteByte('=')
+		if needsLogfmtQuote(field.value) {
+			b.WriteString(fmt.Sprintf("%q", field.value))
+		} else {
+			b.WriteString(field.value)
+		}
+	}
+	return b.String(), nil
+}
+
+func needsLogfmtQuote(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, c := range value {
+		if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// ltsvFormatter renders label:value pairs sorted by label and separated by
+// tabs. Tabs and newlines in values are escaped.
+type ltsvFormatter struct{}
+
+var (
+	ltsvLabelReplacer = strings.NewReplacer(":", "_", "\t", "_", "\n", "_", "\r", "_")
+	ltsvValueReplacer = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`)
+)
+
+func (ltsvFormatter) format(record map[interface{}]interface{}, tag string, ts time.Time, metadata map[interface{}]interface{}) (string, error) {
+	var b strings.Builder
+	for i, field := range sortedFields(record) {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(ltsvLabelReplacer.Replace(field.key))
+		b.WriteByte(':')
+		b.WriteString(ltsvValueReplacer.Replace(field.value))
+	}
+	return b.String(), nil
+}
+
+// templateFormatter executes a Go template with the record, e.g.
+// "{{.level}} {{.msg}}".
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+// templateData is the data of FormatTemplate. Record fields are accessed
+// as {{.key}}, the tag and the timestamp as {{.Tag}} and {{.Time}}, and the
+// event metadata as {{.Metadata.key}}.
+type templateData map[string]interface{}
+
+// The tag, the timestamp and the metadata are kept under keys which
+// templates cannot refer to as fields.
+const (
+	templateTagKey      = "\x00tag"
+	templateTimeKey     = "\x00time"
+	templateMetadataKey = "\x00metadata"
+)
+
+func (d templateData) Tag() string {
+	tag, _ := d[templateTagKey].(string)
+	return tag
+}
+
+func (d templateData) Time() time.Time {
+	ts, _ := d[templateTimeKey].(time.Time)
+	return ts
+}
+
+// Metadata returns the metadata of the event, which is empty unless
+// Fluent Bit v2 or later attached it.
+func (d templateData) Metadata() map[string]interface{} {
+	metadata, _ := d[templateMetadataKey].(map[string]interface{})
+	return metadata
+}
+
+func (f *templateFormatter) format(record map[interface{}]interface{}, tag string, ts time.Time, metadata map[interface{}]interface{}) (string, error) {
+	data := templateData(toJSONMap(record))
+	data[templateTagKey] = tag
+	data[templateTimeKey] = ts
+	data[templateMetadataKey] = toJSONMap(metadata)
+
+	var b strings.Builder
+	if err := f.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+type recordField struct {
+	key   string
+	value string
+}
+
+// sortedFields returns the fields of record as strings sorted by key.
+// Maps and arrays are encoded to JSON.
+func sortedFields(record map[interface{}]interface{}) []recordField {
+	fields := make([]recordField, 0, len(record))
+	for k, v := range record {
+		fields = append(fields, recordField{key: jsonKey(k), value: fieldString(v)})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	return fields
+}
+
+func fieldString(v interface{}) string {
+	switch t := toJSONValue(v).(type) {
+	case string:
+		return t
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		js, err := jsoniter.MarshalToString(t)
+		if err != nil {
+			return fmt.Sprint(t)
+		}
+		return js
+	default:
+		return fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFormatter(t *testing.T) {
+	for format, expected := range map[string]formatter{
+		"":       jsonFormatter{},
+		"json":   jsonFormatter{},
+		"LOGFMT": logfmtFormatter{},
+		"ltsv":   ltsvFormatter{},
+	} {
+		f, err := getFormatter(format, "", "", "", "")
+		assert.NoError(t, err, format)
+		assert.Equal(t, expected, f, format)
+	}
+
+	f, err := getFormatter("template", "{{.level}} {{.msg}}", "", "", "")
+	assert.NoError(t, err)
+	assert.IsType(t, &templateFormatter{}, f)
+
+	_, err = getFormatter("template", "", "", "", "")
+	assert.Error(t, err)
+	_, err = getFormatter("template", "{{.level", "", "", "")
+	assert.Error(t, err)
+	_, err = getFormatter("xml", "", "", "", "")
+	assert.Error(t, err)
+}
+
+func TestFormatters(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"level":  []byte("info"),
+		"msg":    "user logged in",
+		"status": 200,
+		"empty":  "",
+		"kubernetes": map[interface{}]interface{}{
+			"pod_name": []byte("web-1"),
+		},
+		"tags": []interface{}{[]byte("a"), "b"},
+	}
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+
+	for _, tc := range []struct {
+		format   string
+		tmpl     string
+		expected string
+	}{
+		{"logfmt", "", `empty="" kubernetes="{\"pod_name\":\"web-1\"}" level=info msg="user logged in" status=200 tags="[\"a\",\"b\"]"`},
+		{"ltsv", "", "empty:\tkubernetes:{\"pod_name\":\"web-1\"}\tlevel:info\tmsg:user logged in\tstatus:200\ttags:[\"a\",\"b\"]"},
+		{"template", "{{.level}} {{.msg}}", "info user logged in"},
+		{"template", "{{.Time.Format \"2006-01-02T15:04:05Z07:00\"}} [{{.Tag}}] {{.kubernetes.pod_name}}: {{index .tags 0}}", "2019-03-10T10:11:12Z [app.web] web-1: a"},
+	} {
+		f, err := getFormatter(tc.format, tc.tmpl, "", "", "")
+		if err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		message, err := f.format(record, "app.web", ts, nil)
+		assert.NoError(t, err, tc.format)
+		assert.Equal(t, tc.expected, message, tc.format)
+	}
+
+	f, _ := getFormatter("template", "{{.missing}}", "", "", "")
+	_, err := f.format(record, "app.web", ts, nil)
+	assert.Error(t, err)
+}
+
+func TestLTSVFormatterEscapesSeparators(t *testing.T) {
+	message, err := ltsvFormatter{}.format(map[interface{}]interface{}{"a:b": "line1\nline2\tend"}, "", time.Time{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `a_b:line1\nline2\tend`, message)
+}
+
+func TestPluginFlusherWithFormat(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		params:           map[string]string{"Format": "template", "FormatTemplate": "[{{.Tag}}] {{.level}} {{.msg}}"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"level": "warn", "msg": []byte("disk full")})
+	// The record which the template cannot render is sent as JSON.
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"level": "warn"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, "app.disk"))
+	assert.Len(t, testplugin.events, 2)
+	assert.Equal(t, "[app.disk] warn disk full", string(testplugin.events[0].data))
+	assert.Equal(t, `{"level":"warn"}`, string(testplugin.events[1].data))
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+}
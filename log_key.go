@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/json-iterator/go"
+)
+
+// logKey is the record field which is sent as the event message instead
+// of the whole record, e.g. "log" or "$kubernetes['annotations']".
+type logKey struct {
+	name string
+	keys []string
+}
+
+// getLogKeyConfig returns nil when LogKey is not specified.
+func getLogKeyConfig(key string) (*logKey, error) {
+	if key == "" {
+		return nil, nil
+	}
+	keys, err := parseFieldKey("LogKey", key)
+	if err != nil {
+		return nil, err
+	}
+	return &logKey{name: key, keys: keys}, nil
+}
+
+// message returns the value of the field. Strings are sent as they are,
+// and maps and arrays are encoded to JSON. It returns false when the field
+// is missing, null or empty.
+func (k *logKey) message(record map[interface{}]interface{}) (string, bool) {
+	current, ok := lookupField(record, k.keys)
+	if !ok {
+		return "", false
+	}
+
+	var message string
+	switch v := current.(type) {
+	case string:
+		message = validUTF8(v)
+	case []byte:
+		message = validUTF8(string(v))
+	case map[interface{}]interface{}:
+		js, err := createJSON(v)
+		if err != nil {
+			return "", false
+		}
+		message = js
+	case []interface{}:
+		js, err := jsoniter.MarshalToString(toJSONValue(v))
+		if err != nil {
+			return "", false
+		}
+		message = js
+	case nil:
+		return "", false
+	default:
+		message = fmt.Sprint(toJSONValue(v))
+	}
+	if message == "" {
+		return "", false
+	}
+	return message, true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLogKeyConfig(t *testing.T) {
+	key, err := getLogKeyConfig("")
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	key, err = getLogKeyConfig("log")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"log"}, key.keys)
+
+	key, err = getLogKeyConfig("$kubernetes['labels']")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kubernetes", "labels"}, key.keys)
+
+	_, err = getLogKeyConfig("$")
+	assert.Error(t, err)
+	_, err = getLogKeyConfig("$kubernetes['labels'] trailing")
+	assert.Error(t, err)
+}
+
+func TestLogKeyMessage(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"log":    []byte("GET / 200"),
+		"stream": "stdout",
+		"status": 200,
+		"empty":  "",
+		"null":   nil,
+		"kubernetes": map[interface{}]interface{}{
+			"labels": map[interface{}]interface{}{"app": []byte("web")},
+		},
+		"tags": []interface{}{"a", "b"},
+	}
+
+	for key, expected := range map[string]string{
+		"log":                   "GET / 200",
+		"stream":                "stdout",
+		"status":                "200",
+		"$kubernetes['labels']": `{"app":"web"}`,
+		"tags":                  `["a","b"]`,
+	} {
+		k, _ := getLogKeyConfig(key)
+		message, ok := k.message(record)
+		assert.True(t, ok, key)
+		assert.Equal(t, expected, message, key)
+	}
+
+	for _, key := range []string{"missing", "empty", "null", "$log['sub']", "$kubernetes['missing']"} {
+		k, _ := getLogKeyConfig(key)
+		_, ok := k.message(record)
+		assert.False(t, ok, key)
+	}
+}
+
+func TestPluginFlusherWithLogKey(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		params:           map[string]string{"LogKey": "log"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"log": []byte("app line\n"), "stream": "stdout"})
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"message": "no log key"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	assert.Len(t, testplugin.events, 2)
+	assert.Equal(t, "app line\n", string(testplugin.events[0].data))
+	assert.Equal(t, `{"message":"no log key"}`, string(testplugin.events[1].data))
+	assert.Equal(t, uint64(1), pluginContexts[testplugin.contextID].logKeyMisses)
+
+	testplugin.position = 0
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	assert.Equal(t, uint64(2), pluginContexts[testplugin.contextID].logKeyMisses)
+
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+}
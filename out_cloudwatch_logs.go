@@ -3,54 +3,94 @@ package main
 import "github.com/fluent/fluent-bit-go/output"
 import "github.com/json-iterator/go"
 import "github.com/aws/aws-sdk-go/aws"
-import "github.com/aws/aws-sdk-go/aws/awserr"
 import "github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 import "github.com/aws/aws-sdk-go/aws/session"
+import "github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+
+/*
+#include <stdlib.h>
+*/
+import "C"
 
 import (
-	"C"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 	"unsafe"
 )
 
 var plugin GoOutputPlugin = &fluentPlugin{}
-var cloudwatchLogs *cloudwatchlogs.CloudWatchLogs
 
 type cloudWatchLogsConf struct {
-	logGroupName     string
-	logStreamName    string
-	autoCreateStream bool
+	logGroupName    string
+	logStreamName   string
+	logStreamPrefix string
+	logGroupTmpl    *nameTemplate
+	logStreamTmpl   *nameTemplate
+	oversizeEvent   *oversizeEventConf
+	logKey          *logKey
+	formatter       formatter
+	timeKey         *timeKey
 }
 
-type updateToken struct {
-	logGroup  string
-	logStream string
+// pluginContext holds the state of one [OUTPUT] section.
+type pluginContext struct {
+	config *cloudWatchLogsConf
+	client *cwlogs.Client
+	// workers is nil unless Workers is more than 1.
+	workers *workerPool
+	// sender is nil unless Async is enabled.
+	sender *asyncSender
+	// droppedEvents counts the events dropped by OversizeEventPolicy.
+	droppedEvents uint64
+	// logKeyMisses counts the events which did not have LogKey.
+	logKeyMisses uint64
+	// timeKeyMisses counts the events whose TimeKey could not be parsed.
+	timeKeyMisses uint64
 }
 
-var configCtx *cloudWatchLogsConf
-var sequenceTokensCtx map[updateToken]string
+// pluginContexts is indexed by the id which is stored into each
+// output instance's context with FLBPluginSetContext.
+var pluginContexts []*pluginContext
 
 type GoOutputPlugin interface {
 	PluginConfigKey(ctx unsafe.Pointer, key string) string
+	SetContext(ctx unsafe.Pointer, id int)
+	GetContext(ctx unsafe.Pointer) int
+	FreeContext(ctx unsafe.Pointer)
 	Unregister(ctx unsafe.Pointer)
 	GetRecord(dec *output.FLBDecoder) (ret int, ts interface{}, rec map[interface{}]interface{})
 	NewDecoder(data unsafe.Pointer, length int) *output.FLBDecoder
-	Put(logEvents []*cloudwatchlogs.InputLogEvent, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error)
-	CheckLogGroupsExistence(logGroupName string) bool
-	CheckLogStreamsExistence(logGroupName, logStreamName string) (bool, string)
-	CreateLogGroup(logGroupName string) error
-	CreateLogStream(logGroupName, logStreamName string) error
+	cwlogs.API
 	Exit(code int)
 }
 
-type fluentPlugin struct{}
+type fluentPlugin struct {
+	cwlogs.SDKAPI
+}
 
 func (p *fluentPlugin) PluginConfigKey(ctx unsafe.Pointer, key string) string {
 	return output.FLBPluginConfigKey(ctx, key)
 }
 
+func (p *fluentPlugin) SetContext(ctx unsafe.Pointer, id int) {
+	// Fluent Bit keeps the context pointer, so it must not point to Go memory.
+	cid := (*C.int)(C.malloc(C.size_t(unsafe.Sizeof(C.int(0)))))
+	*cid = C.int(id)
+	output.FLBPluginSetContext(ctx, unsafe.Pointer(cid))
+}
+
+func (p *fluentPlugin) GetContext(ctx unsafe.Pointer) int {
+	return int(*(*C.int)(ctx))
+}
+
+// FreeContext frees the id which SetContext allocated.
+func (p *fluentPlugin) FreeContext(ctx unsafe.Pointer) {
+	C.free(ctx)
+}
+
 func (p *fluentPlugin) Unregister(ctx unsafe.Pointer) {
 	output.FLBPluginUnregister(ctx)
 }
@@ -67,211 +107,314 @@ func (p *fluentPlugin) Exit(code int) {
 	os.Exit(code)
 }
 
-func (p *fluentPlugin) Put(logEvents []*cloudwatchlogs.InputLogEvent, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
-	params := &cloudwatchlogs.PutLogEventsInput{
-		LogEvents: logEvents,
-		LogGroupName:  aws.String(configCtx.logGroupName),  // Mandatory
-		LogStreamName: aws.String(configCtx.logStreamName), // Mandatory
+//export FLBPluginRegister
+func FLBPluginRegister(ctx unsafe.Pointer) int {
+	return output.FLBPluginRegister(ctx, "cloudwatch_logs", "ClooudwatchLogs Output plugin written in GO!")
+}
+
+// (fluentbit will call this)
+// ctx (context) pointer to fluentbit context (state/ c code)
+//
+//export FLBPluginInit
+func FLBPluginInit(ctx unsafe.Pointer) int {
+	// Example to retrieve an optional configuration parameter
+	credential := plugin.PluginConfigKey(ctx, "Credential")
+	profile := plugin.PluginConfigKey(ctx, "Profile")
+	accessKeyID := plugin.PluginConfigKey(ctx, "AccessKeyID")
+	secretAccessKey := plugin.PluginConfigKey(ctx, "SecretAccessKey")
+	logGroupName := plugin.PluginConfigKey(ctx, "LogGroupName")
+	logStreamName := plugin.PluginConfigKey(ctx, "LogStreamName")
+	logStreamPrefix := plugin.PluginConfigKey(ctx, "LogStreamPrefix")
+	logGroupTemplate := plugin.PluginConfigKey(ctx, "LogGroupTemplate")
+	logStreamTemplate := plugin.PluginConfigKey(ctx, "LogStreamTemplate")
+	region := plugin.PluginConfigKey(ctx, "Region")
+	autoCreateStream := plugin.PluginConfigKey(ctx, "AutoCreateStream")
+	oversizeEventPolicy := plugin.PluginConfigKey(ctx, "OversizeEventPolicy")
+	oversizeEventMarker := plugin.PluginConfigKey(ctx, "OversizeEventMarker")
+	rejectedEventsFile := plugin.PluginConfigKey(ctx, "RejectedEventsFile")
+	retryPolicy := plugin.PluginConfigKey(ctx, "RetryPolicy")
+	retryMaxAttempts := plugin.PluginConfigKey(ctx, "RetryMaxAttempts")
+	retryBaseDelay := plugin.PluginConfigKey(ctx, "RetryBaseDelay")
+	retryMaxDelay := plugin.PluginConfigKey(ctx, "RetryMaxDelay")
+	deadLetterDir := plugin.PluginConfigKey(ctx, "DeadLetterDir")
+	deadLetterFileSize := plugin.PluginConfigKey(ctx, "DeadLetterFileSize")
+	deadLetterMaxSize := plugin.PluginConfigKey(ctx, "DeadLetterMaxSize")
+	roleARN := plugin.PluginConfigKey(ctx, "RoleARN")
+	externalID := plugin.PluginConfigKey(ctx, "ExternalID")
+	roleSessionName := plugin.PluginConfigKey(ctx, "RoleSessionName")
+	endpoint := plugin.PluginConfigKey(ctx, "Endpoint")
+	stsEndpoint := plugin.PluginConfigKey(ctx, "STSEndpoint")
+	useFIPS := plugin.PluginConfigKey(ctx, "UseFIPS")
+	disableSSL := plugin.PluginConfigKey(ctx, "DisableSSL")
+	httpProxy := plugin.PluginConfigKey(ctx, "HTTPProxy")
+	noProxy := plugin.PluginConfigKey(ctx, "NoProxy")
+	caBundle := plugin.PluginConfigKey(ctx, "CABundle")
+	connectTimeout := plugin.PluginConfigKey(ctx, "ConnectTimeout")
+	requestTimeout := plugin.PluginConfigKey(ctx, "RequestTimeout")
+	maxIdleConns := plugin.PluginConfigKey(ctx, "MaxIdleConns")
+	maxIdleConnsPerHost := plugin.PluginConfigKey(ctx, "MaxIdleConnsPerHost")
+	idleConnTimeout := plugin.PluginConfigKey(ctx, "IdleConnTimeout")
+	ec2MetadataEndpoint := plugin.PluginConfigKey(ctx, "EC2MetadataEndpoint")
+	credentialChain := plugin.PluginConfigKey(ctx, "CredentialChain")
+	credentialProcess := plugin.PluginConfigKey(ctx, "CredentialProcess")
+	credentialProcessTimeout := plugin.PluginConfigKey(ctx, "CredentialProcessTimeout")
+	async := plugin.PluginConfigKey(ctx, "Async")
+	asyncQueueSize := plugin.PluginConfigKey(ctx, "AsyncQueueSize")
+	asyncShutdownTimeout := plugin.PluginConfigKey(ctx, "AsyncShutdownTimeout")
+	asyncRetryLimit := plugin.PluginConfigKey(ctx, "AsyncRetryLimit")
+	workers := plugin.PluginConfigKey(ctx, "Workers")
+	logKeyName := plugin.PluginConfigKey(ctx, "LogKey")
+	format := plugin.PluginConfigKey(ctx, "Format")
+	formatTemplate := plugin.PluginConfigKey(ctx, "FormatTemplate")
+	metricNamespace := plugin.PluginConfigKey(ctx, "MetricNamespace")
+	metricDimensions := plugin.PluginConfigKey(ctx, "MetricDimensions")
+	metricKeys := plugin.PluginConfigKey(ctx, "MetricKeys")
+	timeKeyName := plugin.PluginConfigKey(ctx, "TimeKey")
+	timeFormat := plugin.PluginConfigKey(ctx, "TimeFormat")
+	removeTimeKey := plugin.PluginConfigKey(ctx, "RemoveTimeKey")
+
+	chain, err := cwlogs.ParseCredentialChain(credentialChain)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-	if sequenceToken != "" {
-		params.SequenceToken = aws.String(sequenceToken)
+	processTimeout, err := cwlogs.ParseDurationParameter("CredentialProcessTimeout", credentialProcessTimeout, cwlogs.DefaultCredentialProcessTimeout)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-	resp, err := cloudwatchLogs.PutLogEvents(params)
-
+	endpoints, err := cwlogs.GetEndpointConfig(endpoint, stsEndpoint, useFIPS, disableSSL)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			// Get error details
-			fmt.Println("Error:", awsErr.Code(), awsErr.Message())
-
-			// Prints out full error message, including original error if there was one.
-			fmt.Println("Error:", awsErr.Error())
-			return nil, err
-		} else if err != nil {
-			// A non-service error occurred.
-			// A service error occurred.
-			fmt.Printf("Fatal: %v\n", err)
-			return nil, err
-		}
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-
-	return resp, nil
-}
-
-func (p *fluentPlugin) CheckLogGroupsExistence(logGroupName string) bool {
-	params := &cloudwatchlogs.DescribeLogGroupsInput{
-		LogGroupNamePrefix: aws.String(logGroupName), // Required
+	httpClientConfig, err := cwlogs.GetHTTPClientConfig(httpProxy, noProxy, caBundle, connectTimeout, requestTimeout, maxIdleConns, maxIdleConnsPerHost, idleConnTimeout)
+	if err == nil {
+		endpoints.HTTPClient, err = httpClientConfig.Client()
 	}
-	resp, err := cloudwatchLogs.DescribeLogGroups(params)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return false
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-
-	logGroups := resp.LogGroups
-
-	for _, logGroup := range logGroups {
-		if logGroupName == *logGroup.LogGroupName {
-			return true
-		}
+	credentialOpts := &cwlogs.CredentialOptions{
+		AccessKeyID:         accessKeyID,
+		SecretAccessKey:     secretAccessKey,
+		Credential:          credential,
+		Profile:             profile,
+		EC2MetadataEndpoint: ec2MetadataEndpoint,
+		Region:              region,
+		Endpoints:           endpoints,
+		CredentialChain:     chain,
+
+		CredentialProcess:        credentialProcess,
+		CredentialProcessTimeout: processTimeout,
 	}
-
-	return false
-}
-
-func (p *fluentPlugin) CheckLogStreamsExistence(logGroupName, logStreamName string) (bool, string) {
-	params := &cloudwatchlogs.DescribeLogStreamsInput{
-		LogGroupName:        aws.String(logGroupName),
-		LogStreamNamePrefix: aws.String(logStreamName), // Required
+	config, err := getCloudWatchLogsConfig(credentialOpts, logGroupName, logStreamName, logStreamPrefix, logGroupTemplate, logStreamTemplate, region, autoCreateStream)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-	resp, err := cloudwatchLogs.DescribeLogStreams(params)
+	oversizeConfig, err := getOversizeEventConfig(oversizeEventPolicy, oversizeEventMarker)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return false, ""
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-
-	logStreams := resp.LogStreams
-
-	for _, logStream := range logStreams {
-		if logStreamName == *logStream.LogStreamName {
-			nextToken := *logStream.UploadSequenceToken
-			if nextToken != "" {
-				return true, nextToken
-			} else {
-				return false, ""
-			}
-		}
+	retryConfig, err := cwlogs.GetRetryConfig(retryPolicy, retryMaxAttempts, retryBaseDelay, retryMaxDelay)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-
-	return false, ""
-}
-
-func (p *fluentPlugin) CreateLogGroup(logGroupName string) error {
-	params := &cloudwatchlogs.CreateLogGroupInput{
-		LogGroupName: aws.String(logGroupName), // Required
+	deadLetter, err := cwlogs.GetDeadLetterConfig(deadLetterDir, len(pluginContexts), deadLetterFileSize, deadLetterMaxSize)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-	_, err := cloudwatchLogs.CreateLogGroup(params)
-
+	asyncConfig, err := getAsyncConfig(async, asyncQueueSize, asyncShutdownTimeout, asyncRetryLimit)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			// Get error details
-			fmt.Println("Error:", awsErr.Code(), awsErr.Message())
-
-			// Prints out full error message, including original error if there was one.
-			fmt.Println("Error:", awsErr.Error())
-			return err
-		} else if err != nil {
-			// A non-service error occurred.
-			// A service error occurred.
-			fmt.Printf("Fatal: %v\n", err)
-			return err
-		}
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-
-	return nil
-}
-
-func (p *fluentPlugin) CreateLogStream(logGroupName, logStreamName string) error {
-	params := &cloudwatchlogs.CreateLogStreamInput{
-		LogGroupName:  aws.String(logGroupName),  // Required
-		LogStreamName: aws.String(logStreamName), // Required
+	workerCount, err := getWorkersConfig(workers)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-	_, err := cloudwatchLogs.CreateLogStream(params)
-
+	logKeyConfig, err := getLogKeyConfig(logKeyName)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			// Get error details
-			fmt.Println("Error:", awsErr.Code(), awsErr.Message())
-
-			// Prints out full error message, including original error if there was one.
-			fmt.Println("Error:", awsErr.Error())
-			return err
-		} else if err != nil {
-			// A non-service error occurred.
-			// A service error occurred.
-			fmt.Printf("Fatal: %v\n", err)
-			return err
-		}
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
 	}
-
-	return nil
-}
-
-//export FLBPluginRegister
-func FLBPluginRegister(ctx unsafe.Pointer) int {
-	return output.FLBPluginRegister(ctx, "cloudwatch_logs", "ClooudwatchLogs Output plugin written in GO!")
-}
-
-//export FLBPluginInit
-// (fluentbit will call this)
-// ctx (context) pointer to fluentbit context (state/ c code)
-func FLBPluginInit(ctx unsafe.Pointer) int {
-	// Example to retrieve an optional configuration parameter
-	credential := plugin.PluginConfigKey(ctx, "Credential")
-	accessKeyID := plugin.PluginConfigKey(ctx, "AccessKeyID")
-	secretAccessKey := plugin.PluginConfigKey(ctx, "SecretAccessKey")
-	logStreamName := plugin.PluginConfigKey(ctx, "LogGroupName")
-	logGroupName := plugin.PluginConfigKey(ctx, "LogStreamName")
-	region := plugin.PluginConfigKey(ctx, "Region")
-	autoCreateStream := plugin.PluginConfigKey(ctx, "AutoCreateStream")
-
-	config, err := getCloudWatchLogsConfig(accessKeyID, secretAccessKey, credential, logGroupName, logStreamName, region, autoCreateStream)
+	formatterConfig, err := getFormatter(format, formatTemplate, metricNamespace, metricDimensions, metricKeys)
+	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
+		plugin.Unregister(ctx)
+		plugin.Exit(1)
+		return output.FLB_ERROR
+	}
+	timeKeyConfig, err := getTimeKeyConfig(timeKeyName, timeFormat, removeTimeKey)
 	if err != nil {
+		fmt.Printf("[flb-go] %v\n", err)
 		plugin.Unregister(ctx)
 		plugin.Exit(1)
 		return output.FLB_ERROR
 	}
 	fmt.Printf("[flb-go] plugin credential parameter = '%s'\n", credential)
+	fmt.Printf("[flb-go] plugin profile parameter = '%s'\n", profile)
 	fmt.Printf("[flb-go] plugin accessKeyID parameter = '%s'\n", secretConfig(accessKeyID))
 	fmt.Printf("[flb-go] plugin secretAccessKey parameter = '%s'\n", secretConfig(secretAccessKey))
 	fmt.Printf("[flb-go] plugin logGroupName parameter = '%s'\n", logGroupName)
 	fmt.Printf("[flb-go] plugin logStreamName parameter = '%s'\n", logStreamName)
+	fmt.Printf("[flb-go] plugin logStreamPrefix parameter = '%s'\n", logStreamPrefix)
+	fmt.Printf("[flb-go] plugin logGroupTemplate parameter = '%s'\n", logGroupTemplate)
+	fmt.Printf("[flb-go] plugin logStreamTemplate parameter = '%s'\n", logStreamTemplate)
 	fmt.Printf("[flb-go] plugin region parameter = '%s'\n", region)
 	fmt.Printf("[flb-go] plugin autoCreateStream parameter = '%s'\n", autoCreateStream)
+	fmt.Printf("[flb-go] plugin oversizeEventPolicy parameter = '%s'\n", oversizeEventPolicy)
+	fmt.Printf("[flb-go] plugin oversizeEventMarker parameter = '%s'\n", oversizeEventMarker)
+	fmt.Printf("[flb-go] plugin rejectedEventsFile parameter = '%s'\n", rejectedEventsFile)
+	fmt.Printf("[flb-go] plugin retryPolicy parameter = '%s'\n", retryPolicy)
+	fmt.Printf("[flb-go] plugin retryMaxAttempts parameter = '%s'\n", retryMaxAttempts)
+	fmt.Printf("[flb-go] plugin retryBaseDelay parameter = '%s'\n", retryBaseDelay)
+	fmt.Printf("[flb-go] plugin retryMaxDelay parameter = '%s'\n", retryMaxDelay)
+	fmt.Printf("[flb-go] plugin deadLetterDir parameter = '%s'\n", deadLetterDir)
+	fmt.Printf("[flb-go] plugin deadLetterFileSize parameter = '%s'\n", deadLetterFileSize)
+	fmt.Printf("[flb-go] plugin deadLetterMaxSize parameter = '%s'\n", deadLetterMaxSize)
+	fmt.Printf("[flb-go] plugin roleARN parameter = '%s'\n", roleARN)
+	fmt.Printf("[flb-go] plugin externalID parameter = '%s'\n", secretConfig(externalID))
+	fmt.Printf("[flb-go] plugin roleSessionName parameter = '%s'\n", roleSessionName)
+	fmt.Printf("[flb-go] plugin endpoint parameter = '%s'\n", endpoint)
+	fmt.Printf("[flb-go] plugin stsEndpoint parameter = '%s'\n", stsEndpoint)
+	fmt.Printf("[flb-go] plugin useFIPS parameter = '%s'\n", useFIPS)
+	fmt.Printf("[flb-go] plugin disableSSL parameter = '%s'\n", disableSSL)
+	fmt.Printf("[flb-go] plugin httpProxy parameter = '%s'\n", cwlogs.SecretProxyURL(httpProxy))
+	fmt.Printf("[flb-go] plugin noProxy parameter = '%s'\n", noProxy)
+	fmt.Printf("[flb-go] plugin caBundle parameter = '%s'\n", caBundle)
+	fmt.Printf("[flb-go] plugin connectTimeout parameter = '%s'\n", connectTimeout)
+	fmt.Printf("[flb-go] plugin requestTimeout parameter = '%s'\n", requestTimeout)
+	fmt.Printf("[flb-go] plugin maxIdleConns parameter = '%s'\n", maxIdleConns)
+	fmt.Printf("[flb-go] plugin maxIdleConnsPerHost parameter = '%s'\n", maxIdleConnsPerHost)
+	fmt.Printf("[flb-go] plugin idleConnTimeout parameter = '%s'\n", idleConnTimeout)
+	fmt.Printf("[flb-go] plugin ec2MetadataEndpoint parameter = '%s'\n", ec2MetadataEndpoint)
+	fmt.Printf("[flb-go] plugin credentialChain parameter = '%s'\n", credentialChain)
+	fmt.Printf("[flb-go] plugin credentialProcess parameter = '%s'\n", credentialProcess)
+	fmt.Printf("[flb-go] plugin credentialProcessTimeout parameter = '%s'\n", credentialProcessTimeout)
+	fmt.Printf("[flb-go] plugin async parameter = '%s'\n", async)
+	fmt.Printf("[flb-go] plugin asyncQueueSize parameter = '%s'\n", asyncQueueSize)
+	fmt.Printf("[flb-go] plugin asyncShutdownTimeout parameter = '%s'\n", asyncShutdownTimeout)
+	fmt.Printf("[flb-go] plugin asyncRetryLimit parameter = '%s'\n", asyncRetryLimit)
+	fmt.Printf("[flb-go] plugin workers parameter = '%s'\n", workers)
+	fmt.Printf("[flb-go] plugin logKey parameter = '%s'\n", logKeyName)
+	fmt.Printf("[flb-go] plugin format parameter = '%s'\n", format)
+	fmt.Printf("[flb-go] plugin formatTemplate parameter = '%s'\n", formatTemplate)
+	fmt.Printf("[flb-go] plugin metricNamespace parameter = '%s'\n", metricNamespace)
+	fmt.Printf("[flb-go] plugin metricDimensions parameter = '%s'\n", metricDimensions)
+	fmt.Printf("[flb-go] plugin metricKeys parameter = '%s'\n", metricKeys)
+	fmt.Printf("[flb-go] plugin timeKey parameter = '%s'\n", timeKeyName)
+	fmt.Printf("[flb-go] plugin timeFormat parameter = '%s'\n", timeFormat)
+	fmt.Printf("[flb-go] plugin removeTimeKey parameter = '%s'\n", removeTimeKey)
+
+	if assumeRole := cwlogs.GetAssumeRoleConfig(roleARN, externalID, roleSessionName, endpoints); assumeRole != nil {
+		config.credentials = assumeRole.Credentials(config.credentials, *config.region)
+	}
 
-	sess := session.New(&aws.Config{
-		Credentials: config.credentials,
-		Region:      config.region,
-	})
-	cloudwatchLogs = cloudwatchlogs.New(sess)
-
-	configCtx = &cloudWatchLogsConf{
-		logGroupName:     *config.logGroupName,
-		logStreamName:    *config.logStreamName,
-		autoCreateStream: config.autoCreateStream,
+	sessConfig := endpoints.Config(cwlogs.ServiceCloudWatchLogs, *config.region)
+	sessConfig.Credentials = config.credentials
+	sess := session.New(sessConfig)
+	svc := cloudwatchlogs.New(sess)
+	// CloudWatch Logs extracts the metrics only with the EMF header.
+	if _, ok := formatterConfig.(*emfFormatter); ok {
+		cwlogs.AddEMFHeader(svc)
 	}
 
-	if configCtx.autoCreateStream {
-		if !plugin.CheckLogGroupsExistence(configCtx.logGroupName) {
-			err := plugin.CreateLogGroup(configCtx.logGroupName)
-			if err != nil {
-				fmt.Printf("Failed to create logGroup. error: %v\n", err)
-			}
-		}
+	pctx := &pluginContext{
+		config: &cloudWatchLogsConf{
+			logGroupName:    *config.logGroupName,
+			logStreamName:   *config.logStreamName,
+			logStreamPrefix: *config.logStreamPrefix,
+			logGroupTmpl:    config.logGroupTmpl,
+			logStreamTmpl:   config.logStreamTmpl,
+			oversizeEvent:   oversizeConfig,
+			logKey:          logKeyConfig,
+			formatter:       formatterConfig,
+			timeKey:         timeKeyConfig,
+		},
+		client: cwlogs.NewClient(plugin, svc, config.autoCreateStream, retryConfig, rejectedEventsFile, deadLetter),
 	}
+	configCtx := pctx.config
 
-	if configCtx.autoCreateStream {
-		if doesExist, nextToken := plugin.CheckLogStreamsExistence(configCtx.logGroupName, configCtx.logStreamName); doesExist {
-			sequenceTokensCtx = make(map[updateToken]string)
-			sequenceTokensCtx[updateToken{configCtx.logGroupName, configCtx.logStreamName}] = nextToken
-		} else {
-			err := plugin.CreateLogStream(configCtx.logGroupName, configCtx.logStreamName)
-			if err != nil {
-				fmt.Printf("Failed to create logStream. error: %v\n", err)
-			}
-		}
+	pctx.client.PrepareLogGroup(configCtx.logGroupName)
+
+	// With logStreamPrefix, logStreams are prepared on demand in each flush.
+	if configCtx.logStreamPrefix == "" {
+		pctx.client.PrepareLogStream(configCtx.logGroupName, configCtx.logStreamName)
+	}
+	if workerCount > 1 {
+		pctx.workers = newWorkerPool(pctx.client, workerCount)
+	}
+	if asyncConfig != nil {
+		pctx.sender = newAsyncSender(pctx.client, pctx.workers, retryConfig, asyncConfig)
 	}
 
+	plugin.SetContext(ctx, len(pluginContexts))
+	pluginContexts = append(pluginContexts, pctx)
+
 	return output.FLB_OK
 }
 
+//export FLBPluginFlushCtx
+func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int {
+	return flush(ctx, data, int(length), C.GoString(tag))
+}
+
 //export FLBPluginFlush
 func FLBPluginFlush(data unsafe.Pointer, length C.int, tag *C.char) int {
+	// Fluent Bit versions which do not call FLBPluginFlushCtx pass no
+	// context, so the events are sent by the first output instance.
+	return flushContext(0, data, int(length), C.GoString(tag))
+}
+
+func flush(ctx, data unsafe.Pointer, length int, tag string) int {
+	return flushContext(plugin.GetContext(ctx), data, length, tag)
+}
+
+func flushContext(id int, data unsafe.Pointer, length int, tag string) int {
 	var ret int
 	var ts interface{}
 	var record map[interface{}]interface{}
-	var events []*cloudwatchlogs.InputLogEvent
+	var logKeyMisses, timeKeyMisses uint64
+	c := newChunk()
+
+	pctx := pluginContexts[id]
+	configCtx := pctx.config
+
+	defaultLogStreamName := configCtx.logStreamName
+	if configCtx.logStreamPrefix != "" {
+		defaultLogStreamName = configCtx.logStreamPrefix + sanitizeLogStreamName(tag)
+	}
 
-	dec := plugin.NewDecoder(data, int(length))
+	dec := plugin.NewDecoder(data, length)
 
 	for {
 		ret, ts, record = plugin.GetRecord(dec)
@@ -280,39 +423,103 @@ func FLBPluginFlush(data unsafe.Pointer, length C.int, tag *C.char) int {
 		}
 
 		// Get timestamp
-		var timestamp time.Time
-		switch t := ts.(type) {
-		case output.FLBTime:
-			timestamp = ts.(output.FLBTime).Time
-		case uint64:
-			timestamp = time.Unix(int64(t), 0)
-		default:
-			fmt.Print("timestamp isn't known format. Use current time.\n")
+		timestamp, metadata, ok := eventTime(ts)
+		if !ok {
+			fmt.Printf("timestamp isn't known format: %#v. Use current time.\n", ts)
 			timestamp = time.Now()
 		}
+		if configCtx.timeKey != nil {
+			// The Fluent Bit time is used when the field cannot be parsed,
+			// and the field is kept in the message for the investigation.
+			if t, ok := configCtx.timeKey.timestamp(record); ok {
+				timestamp = t
+				if configCtx.timeKey.remove {
+					record = withoutField(record, configCtx.timeKey.keys)
+				}
+			} else {
+				timeKeyMisses++
+			}
+		}
 
-		line, err := createJSON(record)
-		if err != nil {
-			fmt.Printf("error creating message for CloudWatchLogs: %v\n", err)
+		var line string
+		found := false
+		if configCtx.logKey != nil {
+			if line, found = configCtx.logKey.message(record); !found {
+				logKeyMisses++
+			}
+		}
+		if !found {
+			var err error
+			line, err = configCtx.formatter.format(record, tag, timestamp, metadata)
+			if err != nil {
+				fmt.Printf("error formatting message for CloudWatchLogs: %v. Sending the record as JSON.\n", err)
+				line, err = createJSON(record)
+			}
+			if err != nil {
+				fmt.Printf("error creating message for CloudWatchLogs: %v\n", err)
+				continue
+			}
+		}
+		messages := configCtx.oversizeEvent.apply(line)
+		if messages == nil {
+			pctx.droppedEvents++
+			fmt.Printf("Dropped an event of %d bytes which exceeds the maximum event size. (%d events dropped so far)\n", len(line), pctx.droppedEvents)
 			continue
 		}
 
+		logGroupName := configCtx.logGroupName
+		if configCtx.logGroupTmpl != nil {
+			if name, ok := configCtx.logGroupTmpl.resolve(record, metadata); ok {
+				logGroupName = truncateLogGroupName(sanitizeLogGroupName(name))
+			}
+		}
+		logStreamName := defaultLogStreamName
+		if configCtx.logStreamTmpl != nil {
+			if name, ok := configCtx.logStreamTmpl.resolve(record, metadata); ok {
+				logStreamName = sanitizeLogStreamName(name)
+			}
+		}
+		destination := cwlogs.UpdateToken{LogGroup: logGroupName, LogStream: truncateLogStreamName(logStreamName)}
+
 		t := aws.TimeUnixMilli(timestamp)
-		events = append(events, &cloudwatchlogs.InputLogEvent{ // Mandatory
-			Message:   aws.String(line), // Mandatory
-			Timestamp: aws.Int64(t),     // Mandatory
-		})
+		for _, message := range messages {
+			c.add(destination, &cloudwatchlogs.InputLogEvent{ // Mandatory
+				Message:   aws.String(message), // Mandatory
+				Timestamp: aws.Int64(t),        // Mandatory
+			})
+		}
+	}
+
+	if logKeyMisses > 0 {
+		pctx.logKeyMisses += logKeyMisses
+		fmt.Printf("Sent %d events without LogKey '%s' as the whole record. (%d events so far)\n", logKeyMisses, configCtx.logKey.name, pctx.logKeyMisses)
+	}
+
+	if timeKeyMisses > 0 {
+		pctx.timeKeyMisses += timeKeyMisses
+		fmt.Printf("Used the Fluent Bit time for %d events whose TimeKey '%s' could not be parsed. (%d events so far)\n", timeKeyMisses, configCtx.timeKey.name, pctx.timeKeyMisses)
+	}
+
+	if pctx.sender != nil {
+		if len(c.destinations) == 0 {
+			return output.FLB_OK
+		}
+		// Fluent Bit keeps the chunk and retries it while the queue is full.
+		if !pctx.sender.enqueue(c) {
+			fmt.Printf("Async queue is full. Retrying a chunk of %d events later.\n", c.len())
+			return output.FLB_RETRY
+		}
+		return output.FLB_OK
 	}
 
-	resp, err := plugin.Put(events, sequenceTokensCtx[updateToken{configCtx.logGroupName, configCtx.logStreamName}])
+	dropped, err := c.send(pctx.client, pctx.workers)
 	if err != nil {
 		fmt.Printf("error sending message for CloudWatchLogs: %v\n", err)
-		if rejectedEvent := resp.RejectedLogEventsInfo; rejectedEvent != nil {
-			fmt.Printf("Rejected Event: %s\n", rejectedEvent.GoString())
-		}
 		return output.FLB_RETRY
 	}
-	sequenceTokensCtx[updateToken{configCtx.logGroupName, configCtx.logStreamName}] = nextSequenceToken(resp)
+	if dropped {
+		return output.FLB_ERROR
+	}
 
 	// Return options:
 	//
@@ -322,36 +529,50 @@ func FLBPluginFlush(data unsafe.Pointer, length C.int, tag *C.char) int {
 	return output.FLB_OK
 }
 
-func secretConfig(parameter string) string {
-	if parameter != "" {
-		return "xxxxxx"
-	} else {
-		return ""
+// CloudWatch Logs does not allow ':' and '*' in logStream names,
+// and they must be between 1 and 512 characters.
+const maxLogStreamNameLength = 512
+
+var logStreamNameReplacer = strings.NewReplacer(":", "_", "*", "_")
+
+// logGroup names consist of a-z, A-Z, 0-9, '_', '-', '/', '.' and '#',
+// and they must be between 1 and 512 characters.
+const maxLogGroupNameLength = 512
+
+var invalidLogGroupNameChars = regexp.MustCompile(`[^a-zA-Z0-9_\-/.#]`)
+
+func sanitizeLogGroupName(name string) string {
+	return invalidLogGroupNameChars.ReplaceAllString(name, "_")
+}
+
+func truncateLogGroupName(name string) string {
+	if len(name) > maxLogGroupNameLength {
+		return name[:maxLogGroupNameLength]
+	}
+	return name
+}
+
+func sanitizeLogStreamName(name string) string {
+	return logStreamNameReplacer.Replace(name)
+}
+
+func truncateLogStreamName(name string) string {
+	if len(name) > maxLogStreamNameLength {
+		return name[:maxLogStreamNameLength]
 	}
+	return name
 }
 
-func nextSequenceToken(response *cloudwatchlogs.PutLogEventsOutput) string {
-	if response != nil {
-		return *response.NextSequenceToken
+func secretConfig(parameter string) string {
+	if parameter != "" {
+		return "xxxxxx"
 	} else {
 		return ""
 	}
 }
 
 func createJSON(record map[interface{}]interface{}) (string, error) {
-	m := make(map[string]interface{})
-
-	for k, v := range record {
-		switch t := v.(type) {
-		case []byte:
-			// prevent encoding to base64
-			m[k.(string)] = string(t)
-		default:
-			m[k.(string)] = v
-		}
-	}
-
-	js, err := jsoniter.Marshal(m)
+	js, err := jsoniter.Marshal(toJSONMap(record))
 	if err != nil {
 		return "{}", err
 	}
@@ -359,8 +580,36 @@ func createJSON(record map[interface{}]interface{}) (string, error) {
 	return string(js), nil
 }
 
+// closeContext drains the async queue of an output instance, and releases
+// its resources.
+func closeContext(id int) {
+	pctx := pluginContexts[id]
+	if pctx == nil {
+		return
+	}
+	if pctx.sender != nil {
+		pctx.sender.shutdown()
+	} else {
+		pctx.workers.close()
+		pctx.client.Close()
+	}
+	pluginContexts[id] = nil
+}
+
+//export FLBPluginExitCtx
+func FLBPluginExitCtx(ctx unsafe.Pointer) int {
+	closeContext(plugin.GetContext(ctx))
+	plugin.FreeContext(ctx)
+	return output.FLB_OK
+}
+
 //export FLBPluginExit
 func FLBPluginExit() int {
+	// Fluent Bit versions which do not call FLBPluginExitCtx leave the
+	// contexts open.
+	for id := range pluginContexts {
+		closeContext(id)
+	}
 	return output.FLB_OK
 }
 
@@ -2,12 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 	"unsafe"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
 	"github.com/fluent/fluent-bit-go/output"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,6 +42,12 @@ func TestCreateJSON(t *testing.T) {
 	assert.Equal(t, result["number"], float64(8))
 }
 
+func TestSanitizeLogStreamName(t *testing.T) {
+	assert.Equal(t, "kube.var.log", sanitizeLogStreamName("kube.var.log"))
+	assert.Equal(t, "app_web_", sanitizeLogStreamName("app:web*"))
+	assert.Len(t, truncateLogStreamName(strings.Repeat("a", 600)), maxLogStreamNameLength)
+}
+
 func TestSecretConfig(t *testing.T) {
 	parameter := "The secret parameter"
 	result := secretConfig(parameter)
@@ -52,7 +67,10 @@ type testrecord struct {
 }
 
 type events struct {
-	data []byte
+	data          []byte
+	timestamp     int64
+	logGroupName  string
+	logStreamName string
 }
 type testFluentPlugin struct {
 	credential       string
@@ -60,11 +78,32 @@ type testFluentPlugin struct {
 	secretAccessKey  string
 	logGroupName     string
 	logStreamName    string
+	logStreamPrefix  string
+	logGroupTemplate string
+	logStreamTmpl    string
+	oversizePolicy   string
 	region           string
 	autoCreateStream string
 	records          []testrecord
 	position         int
 	events           []*events
+	contextID        int
+	createdStreams   []string
+	putCalls         int
+	failPutCall      int
+	putErrors        map[int]error
+	acceptedPutCall  int
+	sequenceToken    string
+	sentTokens       []string
+	rejectedInfo     *cloudwatchlogs.RejectedLogEventsInfo
+	rejectedFile     string
+	params           map[string]string
+	// With putGate, Put signals putStarted and waits for putGate.
+	putStarted chan struct{}
+	putGate    chan struct{}
+	// mu guards the fields which Put and CreateLogStream update, as they
+	// are called by the worker goroutines.
+	mu sync.Mutex
 }
 
 func (p *testFluentPlugin) PluginConfigKey(ctx unsafe.Pointer, key string) string {
@@ -79,15 +118,38 @@ func (p *testFluentPlugin) PluginConfigKey(ctx unsafe.Pointer, key string) strin
 		return p.logGroupName
 	case "LogStreamName":
 		return p.logStreamName
+	case "LogStreamPrefix":
+		return p.logStreamPrefix
+	case "LogGroupTemplate":
+		return p.logGroupTemplate
+	case "LogStreamTemplate":
+		return p.logStreamTmpl
 	case "Region":
 		return p.region
 	case "AutoCreateStream":
 		return p.autoCreateStream
+	case "OversizeEventPolicy":
+		return p.oversizePolicy
+	case "OversizeEventMarker", "RoleARN", "ExternalID", "RoleSessionName", "STSEndpoint",
+		"EC2MetadataEndpoint", "CredentialChain", "CredentialProcess", "CredentialProcessTimeout",
+		"Profile", "Endpoint", "UseFIPS", "DisableSSL", "HTTPProxy", "NoProxy", "CABundle",
+		"ConnectTimeout", "RequestTimeout", "MaxIdleConns", "MaxIdleConnsPerHost", "IdleConnTimeout",
+		"RetryPolicy", "RetryMaxAttempts", "RetryBaseDelay", "RetryMaxDelay",
+		"DeadLetterDir", "DeadLetterFileSize", "DeadLetterMaxSize",
+		"Async", "AsyncQueueSize", "AsyncShutdownTimeout", "AsyncRetryLimit", "Workers", "LogKey",
+		"Format", "FormatTemplate", "TimeKey", "TimeFormat", "RemoveTimeKey",
+		"MetricNamespace", "MetricDimensions", "MetricKeys":
+		return p.params[key]
+	case "RejectedEventsFile":
+		return p.rejectedFile
 	}
 	return "unknown-" + key
 }
 
-func (p *testFluentPlugin) Unregister(ctx unsafe.Pointer) {}
+func (p *testFluentPlugin) SetContext(ctx unsafe.Pointer, id int) { p.contextID = id }
+func (p *testFluentPlugin) GetContext(ctx unsafe.Pointer) int     { return p.contextID }
+func (p *testFluentPlugin) FreeContext(ctx unsafe.Pointer)        {}
+func (p *testFluentPlugin) Unregister(ctx unsafe.Pointer)         {}
 func (p *testFluentPlugin) GetRecord(dec *output.FLBDecoder) (int, interface{}, map[interface{}]interface{}) {
 	if p.position < len(p.records) {
 		r := p.records[p.position]
@@ -98,28 +160,56 @@ func (p *testFluentPlugin) GetRecord(dec *output.FLBDecoder) (int, interface{},
 }
 func (p *testFluentPlugin) NewDecoder(data unsafe.Pointer, length int) *output.FLBDecoder { return nil }
 func (p *testFluentPlugin) Exit(code int)                                                 {}
-func (p *testFluentPlugin) Put(logEvents []*cloudwatchlogs.InputLogEvent, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
+func (p *testFluentPlugin) Put(client *cloudwatchlogs.CloudWatchLogs, logEvents []*cloudwatchlogs.InputLogEvent, logGroupName, logStreamName, sequenceToken string) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if p.putGate != nil {
+		p.putStarted <- struct{}{}
+		<-p.putGate
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.putCalls++
+	p.sentTokens = append(p.sentTokens, sequenceToken)
+	if p.putCalls == p.failPutCall {
+		return nil, errors.New("put failure")
+	}
+	if err, ok := p.putErrors[p.putCalls]; ok {
+		return nil, err
+	}
+	if p.putCalls == p.acceptedPutCall {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeDataAlreadyAcceptedException,
+			"The given batch of log events has already been accepted. The next batch can be sent with sequenceToken: "+p.sequenceToken, nil)
+	}
+	if p.sequenceToken != "" && sequenceToken != p.sequenceToken {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidSequenceTokenException,
+			"The given sequenceToken is invalid. The next expected sequenceToken is: "+p.sequenceToken, nil)
+	}
 	for _, logEvent := range logEvents {
 		data := ([]byte)(*logEvent.Message)
-		events := &events{data: data}
+		events := &events{data: data, timestamp: *logEvent.Timestamp, logGroupName: logGroupName, logStreamName: logStreamName}
 		p.events = append(p.events, events)
 	}
-	return nil, nil
+	return &cloudwatchlogs.PutLogEventsOutput{RejectedLogEventsInfo: p.rejectedInfo}, nil
 }
 
-func (p *testFluentPlugin) CheckLogGroupsExistence(logGroupName string) bool {
+func (p *testFluentPlugin) CheckLogGroupsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) bool {
 	return true
 }
 
-func (p *testFluentPlugin) CheckLogStreamsExistence(logGroupName, logStreamName string) (bool, string) {
+func (p *testFluentPlugin) CheckLogStreamsExistence(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) (bool, string) {
+	if p.logStreamPrefix != "" {
+		return false, ""
+	}
 	return true, ""
 }
 
-func (p *testFluentPlugin) CreateLogGroup(logGroupName string) error {
+func (p *testFluentPlugin) CreateLogGroup(client *cloudwatchlogs.CloudWatchLogs, logGroupName string) error {
 	return nil
 }
 
-func (p *testFluentPlugin) CreateLogStream(logGroupName, logStreamName string) error {
+func (p *testFluentPlugin) CreateLogStream(client *cloudwatchlogs.CloudWatchLogs, logGroupName, logStreamName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.createdStreams = append(p.createdStreams, logStreamName)
 	return nil
 }
 
@@ -146,7 +236,7 @@ type testCloudwatchLogsCredential struct {
 	credential string
 }
 
-func (c *testCloudwatchLogsCredential) GetCredentials(accessID, secretkey, credential string) (*credentials.Credentials, error) {
+func (c *testCloudwatchLogsCredential) GetCredentials(opts *cwlogs.CredentialOptions) (*credentials.Credentials, error) {
 	creds := credentials.NewCredentials(&stubProvider{
 		creds: credentials.Value{
 			AccessKeyID:     "AKID",
@@ -161,7 +251,7 @@ func (c *testCloudwatchLogsCredential) GetCredentials(accessID, secretkey, crede
 
 func TestPluginInitializationWithStaticCredentials(t *testing.T) {
 	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
-	_, err := getCloudWatchLogsConfig("exampleaccessID", "examplesecretkey", "", "examplegroup", "examplestream", "exampleregion", "")
+	_, err := getCloudWatchLogsConfig(&cwlogs.CredentialOptions{AccessKeyID: "exampleaccessID", SecretAccessKey: "examplesecretkey"}, "examplegroup", "examplestream", "", "", "", "exampleregion", "")
 	if err != nil {
 		t.Fatalf("failed test %#v", err)
 	}
@@ -179,7 +269,7 @@ func TestPluginInitializationWithStaticCredentials(t *testing.T) {
 
 func TestPluginInitializationWithSharedCredentials(t *testing.T) {
 	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
-	_, err := getCloudWatchLogsConfig("", "", "examplecredentials", "examplegroup", "examplestream", "exampleregion", "true")
+	_, err := getCloudWatchLogsConfig(&cwlogs.CredentialOptions{Credential: "examplecredentials"}, "examplegroup", "examplestream", "", "", "", "exampleregion", "true")
 	if err != nil {
 		t.Fatalf("failed test %#v", err)
 	}
@@ -195,14 +285,18 @@ func TestPluginInitializationWithSharedCredentials(t *testing.T) {
 }
 
 func TestPluginFlusher(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
 	testplugin := &testFluentPlugin{
 		credential:       "examplecredentials",
 		accessKeyID:      "exampleaccesskeyid",
 		secretAccessKey:  "examplesecretaccesskey",
 		logGroupName:     "examplegroup",
 		logStreamName:    "examplestream",
+		region:           "exampleregion",
 		autoCreateStream: "true",
 	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
 	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
 	testrecords := map[interface{}]interface{}{
 		"mykey": "myvalue",
@@ -210,8 +304,7 @@ func TestPluginFlusher(t *testing.T) {
 	testplugin.addrecord(0, output.FLBTime{Time: ts}, testrecords)
 	testplugin.addrecord(0, uint64(ts.Unix()), testrecords)
 	testplugin.addrecord(0, 0, testrecords)
-	plugin = testplugin
-	res := FLBPluginFlush(nil, 0, nil)
+	res := FLBPluginFlushCtx(nil, nil, 0, nil)
 	assert.Equal(t, output.FLB_OK, res)
 	assert.Len(t, testplugin.events, len(testplugin.records))
 	var parsed map[string]interface{}
@@ -220,3 +313,524 @@ func TestPluginFlusher(t *testing.T) {
 	json.Unmarshal(testplugin.events[1].data, &parsed)
 	json.Unmarshal(testplugin.events[2].data, &parsed)
 }
+
+func TestPluginMultipleInstances(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	first := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "firstgroup",
+		logStreamName:    "firststream",
+		region:           "us-east-1",
+		autoCreateStream: "true",
+	}
+	second := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "secondgroup",
+		logStreamName:    "secondstream",
+		region:           "ap-northeast-1",
+		autoCreateStream: "true",
+	}
+	plugin = first
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+	plugin = second
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+	assert.NotEqual(t, first.contextID, second.contextID)
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	first.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "first"})
+	second.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "second"})
+
+	plugin = first
+	assert.Equal(t, output.FLB_OK, FLBPluginFlushCtx(nil, nil, 0, nil))
+	plugin = second
+	assert.Equal(t, output.FLB_OK, FLBPluginFlushCtx(nil, nil, 0, nil))
+
+	assert.Len(t, first.events, 1)
+	assert.Equal(t, "firstgroup", first.events[0].logGroupName)
+	assert.Equal(t, "firststream", first.events[0].logStreamName)
+	assert.Len(t, second.events, 1)
+	assert.Equal(t, "secondgroup", second.events[0].logGroupName)
+	assert.Equal(t, "secondstream", second.events[0].logStreamName)
+
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+	assert.Nil(t, pluginContexts[second.contextID])
+	assert.NotNil(t, pluginContexts[first.contextID])
+}
+
+func TestPluginFlusherWithLogStreamPrefix(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamPrefix:  "fluent-bit-",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+	assert.Empty(t, testplugin.createdStreams)
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "app:web")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Len(t, testplugin.events, 1)
+	assert.Equal(t, "examplegroup", testplugin.events[0].logGroupName)
+	assert.Equal(t, "fluent-bit-app_web", testplugin.events[0].logStreamName)
+	assert.Equal(t, []string{"fluent-bit-app_web"}, testplugin.createdStreams)
+
+	// The logStream is created only once.
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res = flush(nil, nil, 0, "app:web")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Len(t, testplugin.events, 2)
+	assert.Equal(t, []string{"fluent-bit-app_web"}, testplugin.createdStreams)
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- data
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+func TestPluginInitReportsInvalidTemplate(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	plugin = &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logGroupTemplate: "/eks/$kubernetes['namespace_name'",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+	}
+	var res int
+	out := captureStdout(t, func() { res = FLBPluginInit(nil) })
+	assert.Equal(t, output.FLB_ERROR, res)
+	assert.Contains(t, out, `[flb-go] Invalid template "/eks/$kubernetes['namespace_name'"`)
+}
+
+func TestPluginInitReportsFIPSRegion(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	plugin = &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "eu-west-1",
+		autoCreateStream: "true",
+		params:           map[string]string{"UseFIPS": "true"},
+	}
+	var res int
+	out := captureStdout(t, func() { res = FLBPluginInit(nil) })
+	assert.Equal(t, output.FLB_ERROR, res)
+	assert.Contains(t, out, "[flb-go] UseFIPS is not available in region eu-west-1")
+}
+
+func TestPluginFlusherWithTemplates(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		logGroupTemplate: "/eks/$kubernetes['namespace_name']",
+		logStreamTmpl:    "$kubernetes['pod_name']",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	kubernetes := func(namespace, pod string) map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"log": "message",
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte(namespace),
+				"pod_name":       []byte(pod),
+			},
+		}
+	}
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, kubernetes("default", "web-1"))
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, kubernetes("kube-system", "dns-1"))
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, kubernetes("default", "web-1"))
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"log": "no metadata"})
+
+	res := flush(nil, nil, 0, "kube.var.log")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Len(t, testplugin.events, 4)
+
+	// Events are grouped by destination in the order of their first appearance.
+	destinations := []cwlogs.UpdateToken{}
+	for _, e := range testplugin.events {
+		destinations = append(destinations, cwlogs.UpdateToken{LogGroup: e.logGroupName, LogStream: e.logStreamName})
+	}
+	assert.Equal(t, []cwlogs.UpdateToken{
+		{LogGroup: "/eks/default", LogStream: "web-1"},
+		{LogGroup: "/eks/default", LogStream: "web-1"},
+		{LogGroup: "/eks/kube-system", LogStream: "dns-1"},
+		{LogGroup: "examplegroup", LogStream: "examplestream"},
+	}, destinations)
+}
+
+func TestPluginFlusherRetriesOnlyUndeliveredBatches(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	// Three events which span more than 24 hours are sent in two batches.
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testrecords := map[interface{}]interface{}{"mykey": "myvalue"}
+	addRecords := func() {
+		testplugin.position = 0
+		testplugin.records = nil
+		testplugin.addrecord(0, output.FLBTime{Time: ts.Add(25 * time.Hour)}, testrecords)
+		testplugin.addrecord(0, output.FLBTime{Time: ts}, testrecords)
+		testplugin.addrecord(0, output.FLBTime{Time: ts.Add(time.Hour)}, testrecords)
+	}
+
+	// The first batch is accepted and the second one fails.
+	addRecords()
+	testplugin.failPutCall = 2
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_RETRY, res)
+	assert.Equal(t, 2, testplugin.putCalls)
+	assert.Len(t, testplugin.events, 2)
+
+	// fluent-bit retries the same chunk. Only the failed batch is sent.
+	addRecords()
+	testplugin.putCalls = 0
+	testplugin.failPutCall = 0
+	res = flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Equal(t, 1, testplugin.putCalls)
+	assert.Len(t, testplugin.events, 3)
+}
+
+func TestPluginFlusherRetriesChunkAfterAnotherChunk(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	// Chunk A is sent in two batches, since its events span more than 24
+	// hours. Chunk B has one event.
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	setChunk := func(records ...testrecord) {
+		testplugin.position = 0
+		testplugin.records = records
+	}
+	chunkA := []testrecord{
+		{ts: output.FLBTime{Time: ts}, data: map[interface{}]interface{}{"k": "a1"}},
+		{ts: output.FLBTime{Time: ts.Add(25 * time.Hour)}, data: map[interface{}]interface{}{"k": "a2"}},
+	}
+	chunkB := []testrecord{
+		{ts: output.FLBTime{Time: ts}, data: map[interface{}]interface{}{"k": "b1"}},
+	}
+
+	// The first batch of A is accepted and the second one fails.
+	setChunk(chunkA...)
+	testplugin.failPutCall = 2
+	assert.Equal(t, output.FLB_RETRY, flush(nil, nil, 0, ""))
+
+	// B is delivered before fluent-bit retries A.
+	setChunk(chunkB...)
+	testplugin.failPutCall = 0
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+
+	// The retry of A does not send the accepted batch again.
+	setChunk(chunkA...)
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	var messages []string
+	for _, e := range testplugin.events {
+		messages = append(messages, string(e.data))
+	}
+	assert.Equal(t, []string{`{"k":"a1"}`, `{"k":"b1"}`, `{"k":"a2"}`}, messages)
+}
+
+func TestPluginFlusherDropsOversizeEvents(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		oversizePolicy:   "drop",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"log": strings.Repeat("a", maxEventSize)})
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"log": "small"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Len(t, testplugin.events, 1)
+	assert.Equal(t, uint64(1), pluginContexts[testplugin.contextID].droppedEvents)
+}
+
+func TestPluginFlusherRecoversFromInvalidSequenceToken(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	// Another agent has written to the logStream.
+	testplugin.sequenceToken = "expectedtoken"
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Equal(t, []string{"", "expectedtoken"}, testplugin.sentTokens)
+	assert.Len(t, testplugin.events, 1)
+}
+
+func TestPluginFlusherTreatsDataAlreadyAcceptedAsSuccess(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		acceptedPutCall:  1,
+		sequenceToken:    "nexttoken",
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Equal(t, 1, testplugin.putCalls)
+	assert.Empty(t, testplugin.events)
+	assert.Equal(t, "nexttoken", pluginContexts[testplugin.contextID].client.SequenceToken(cwlogs.UpdateToken{LogGroup: "examplegroup", LogStream: "examplestream"}))
+}
+
+func TestPluginFlusherWritesRejectedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rejected")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		rejectedInfo: &cloudwatchlogs.RejectedLogEventsInfo{
+			TooOldLogEventEndIndex:   aws.Int64(1),
+			TooNewLogEventStartIndex: aws.Int64(2),
+		},
+		rejectedFile: filepath.Join(dir, "rejected.log"),
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "old"})
+	testplugin.addrecord(0, output.FLBTime{Time: ts.Add(time.Hour)}, map[interface{}]interface{}{"mykey": "accepted"})
+	testplugin.addrecord(0, output.FLBTime{Time: ts.Add(2 * time.Hour)}, map[interface{}]interface{}{"mykey": "new"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_OK, res)
+
+	content, err := ioutil.ReadFile(testplugin.rejectedFile)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	var rejected struct {
+		LogGroup  string `json:"log_group"`
+		LogStream string `json:"log_stream"`
+		Reason    string `json:"reason"`
+		Timestamp int64  `json:"timestamp"`
+		Message   string `json:"message"`
+	}
+	json.Unmarshal([]byte(lines[0]), &rejected)
+	assert.Equal(t, "too_old", rejected.Reason)
+	assert.Equal(t, aws.TimeUnixMilli(ts), rejected.Timestamp)
+	assert.Equal(t, `{"mykey":"old"}`, rejected.Message)
+	json.Unmarshal([]byte(lines[1]), &rejected)
+	assert.Equal(t, "too_new", rejected.Reason)
+	assert.Equal(t, "examplegroup", rejected.LogGroup)
+	assert.Equal(t, "examplestream", rejected.LogStream)
+}
+
+func TestPluginFlusherBacksOffOnThrottling(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors:        map[int]error{1: throttled, 2: throttled},
+		params:           map[string]string{"RetryMaxAttempts": "2", "RetryBaseDelay": "1ms", "RetryMaxDelay": "1ms"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_OK, res)
+	assert.Equal(t, 3, testplugin.putCalls)
+	assert.Len(t, testplugin.events, 1)
+
+	// Fluent Bit retries the chunk after RetryMaxAttempts.
+	testplugin.putCalls = 0
+	testplugin.putErrors = map[int]error{1: throttled, 2: throttled, 3: throttled}
+	testplugin.position = 0
+	testplugin.records[0].data = map[interface{}]interface{}{"mykey": "othervalue"}
+	res = flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_RETRY, res)
+	assert.Equal(t, 3, testplugin.putCalls)
+}
+
+func TestPluginFlusherDropsInvalidBatches(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors: map[int]error{
+			1: awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid", nil),
+		},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_ERROR, res)
+	assert.Equal(t, 1, testplugin.putCalls)
+	assert.Equal(t, uint64(1), pluginContexts[testplugin.contextID].client.DroppedBatches())
+}
+
+func TestPluginFlusherRetryPolicyOverridesClassification(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors: map[int]error{
+			1: awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid", nil),
+		},
+		params: map[string]string{"RetryPolicy": "InvalidParameterException=retry"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_RETRY, res)
+	assert.Equal(t, 1, testplugin.putCalls)
+}
+
+func TestPluginFlusherWritesDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		putErrors: map[int]error{
+			1: awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid", nil),
+		},
+		params: map[string]string{"DeadLetterDir": dir, "RetryMaxAttempts": "1", "RetryBaseDelay": "1ms", "RetryMaxDelay": "1ms"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ts}, map[interface{}]interface{}{"mykey": "myvalue"})
+	res := flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_ERROR, res)
+
+	// The batches which exhausted the retries with backoff are not retried
+	// by Fluent Bit either.
+	testplugin.putCalls = 0
+	testplugin.putErrors = map[int]error{1: throttled, 2: throttled}
+	testplugin.position = 0
+	testplugin.records[0].data = map[interface{}]interface{}{"mykey": "othervalue"}
+	res = flush(nil, nil, 0, "")
+	assert.Equal(t, output.FLB_ERROR, res)
+	assert.Equal(t, 2, testplugin.putCalls)
+	assert.Equal(t, uint64(2), pluginContexts[testplugin.contextID].client.DroppedBatches())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "dead-letter-*.ndjson"))
+	assert.Len(t, files, 1)
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	var events []cwlogs.DeadLetterEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event cwlogs.DeadLetterEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		events = append(events, event)
+	}
+	assert.Len(t, events, 2)
+	assert.Equal(t, cloudwatchlogs.ErrCodeInvalidParameterException, events[0].ErrorCode)
+	assert.Equal(t, `{"mykey":"myvalue"}`, events[0].Message)
+	assert.Equal(t, ts.UnixNano()/int64(time.Millisecond), events[0].Timestamp)
+	assert.Equal(t, "ThrottlingException", events[1].ErrorCode)
+	assert.Equal(t, `{"mykey":"othervalue"}`, events[1].Message)
+	assert.Equal(t, "examplegroup", events[1].LogGroup)
+	assert.Equal(t, "examplestream", events[1].LogStream)
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+}
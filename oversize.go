@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+)
+
+// The maximum event size of CloudWatch Logs, which includes the 26 bytes
+// of overhead per event.
+const (
+	maxEventSize   = 262144
+	maxMessageSize = maxEventSize - cwlogs.EventSizeOverhead
+)
+
+type oversizeEventPolicy int
+
+const (
+	oversizeEventTruncate oversizeEventPolicy = iota
+	oversizeEventSplit
+	oversizeEventDrop
+)
+
+const defaultOversizeEventMarker = "...(truncated)"
+
+type oversizeEventConf struct {
+	policy oversizeEventPolicy
+	marker string
+}
+
+func getOversizeEventConfig(policy, marker string) (*oversizeEventConf, error) {
+	conf := &oversizeEventConf{}
+
+	switch strings.ToLower(policy) {
+	case "", "truncate":
+		conf.policy = oversizeEventTruncate
+	case "split":
+		conf.policy = oversizeEventSplit
+	case "drop":
+		conf.policy = oversizeEventDrop
+	default:
+		return nil, fmt.Errorf("Unknown OversizeEventPolicy: %s", policy)
+	}
+
+	if marker == "" {
+		marker = defaultOversizeEventMarker
+	}
+	if len(marker) >= maxMessageSize {
+		return nil, fmt.Errorf("OversizeEventMarker is too long")
+	}
+	conf.marker = marker
+
+	return conf, nil
+}
+
+// apply returns the messages which are sent instead of line. It returns
+// nil when line is dropped.
+func (c *oversizeEventConf) apply(line string) []string {
+	if len(line) <= maxMessageSize {
+		return []string{line}
+	}
+
+	switch c.policy {
+	case oversizeEventSplit:
+		return splitMessage(line)
+	case oversizeEventDrop:
+		return nil
+	default:
+		return []string{utf8Prefix(line, maxMessageSize-len(c.marker)) + c.marker}
+	}
+}
+
+// splitMessage splits line into numbered continuation messages such as
+// "[<id> 1/3] ...". The id is derived from line, so that the same line
+// is always split into the same messages.
+func splitMessage(line string) []string {
+	sum := sha1.Sum([]byte(line))
+	id := hex.EncodeToString(sum[:8])
+	// Room for "[<id> <n>/<total>] ".
+	digits := len(strconv.Itoa(len(line)))
+	partSize := maxMessageSize - (len(id) + 2*digits + 5)
+
+	var parts []string
+	for rest := line; rest != ""; {
+		part := utf8Prefix(rest, partSize)
+		if part == "" {
+			// An invalid UTF-8 sequence at the head.
+			part = rest[:1]
+		}
+		parts = append(parts, part)
+		rest = rest[len(part):]
+	}
+
+	messages := make([]string, len(parts))
+	for i, part := range parts {
+		messages[i] = fmt.Sprintf("[%s %d/%d] %s", id, i+1, len(parts), part)
+	}
+	return messages
+}
+
+// utf8Prefix returns the longest prefix of s which fits in n bytes
+// without breaking a multi-byte character.
+func utf8Prefix(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
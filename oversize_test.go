@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOversizeEventConfig(t *testing.T) {
+	conf, err := getOversizeEventConfig("", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, oversizeEventTruncate, conf.policy)
+	assert.Equal(t, defaultOversizeEventMarker, conf.marker)
+
+	conf, err = getOversizeEventConfig("Split", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, oversizeEventSplit, conf.policy)
+
+	conf, err = getOversizeEventConfig("drop", "")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, oversizeEventDrop, conf.policy)
+
+	_, err = getOversizeEventConfig("ignore", "")
+	assert.Error(t, err)
+}
+
+func TestOversizeEventSmallLine(t *testing.T) {
+	for _, policy := range []string{"truncate", "split", "drop"} {
+		conf, _ := getOversizeEventConfig(policy, "")
+		assert.Equal(t, []string{"small"}, conf.apply("small"))
+	}
+}
+
+func TestOversizeEventTruncate(t *testing.T) {
+	conf, _ := getOversizeEventConfig("truncate", "[cut]")
+	// A multi-byte character lies on the limit.
+	line := strings.Repeat("a", maxMessageSize-len("[cut]")-1) + "あ" + strings.Repeat("b", 100)
+
+	messages := conf.apply(line)
+	assert.Len(t, messages, 1)
+	assert.True(t, len(messages[0]) <= maxMessageSize)
+	assert.True(t, strings.HasSuffix(messages[0], "[cut]"))
+	assert.True(t, utf8.ValidString(messages[0]))
+}
+
+func TestOversizeEventSplit(t *testing.T) {
+	conf, _ := getOversizeEventConfig("split", "")
+	line := strings.Repeat("あいう", maxMessageSize/3)
+
+	messages := conf.apply(line)
+	assert.Len(t, messages, 4)
+
+	var id string
+	var joined strings.Builder
+	for i, message := range messages {
+		assert.True(t, len(message) <= maxMessageSize)
+		assert.True(t, utf8.ValidString(message))
+
+		var partID string
+		var n, total int
+		_, err := fmt.Sscanf(message, "[%s %d/%d]", &partID, &n, &total)
+		if err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		if i == 0 {
+			id = partID
+		}
+		assert.Equal(t, id, partID)
+		assert.Equal(t, i+1, n)
+		assert.Equal(t, len(messages), total)
+		joined.WriteString(message[strings.Index(message, "] ")+2:])
+	}
+	assert.Equal(t, line, joined.String())
+
+	// The same line is split into the same messages.
+	assert.Equal(t, messages, conf.apply(line))
+}
+
+func TestOversizeEventDrop(t *testing.T) {
+	conf, _ := getOversizeEventConfig("drop", "")
+	assert.Nil(t, conf.apply(strings.Repeat("a", maxMessageSize+1)))
+}
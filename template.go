@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nameTemplate is a logGroup or logStream name which refers to record
+// fields with record accessors, e.g. "/eks/$kubernetes['namespace_name']".
+// The metadata of Fluent Bit v2 events is referred to as
+// "$_metadata['key']".
+type nameTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is either a literal string or a record accessor.
+type templatePart struct {
+	literal string
+	keys    []string
+	// metadata looks up keys in the event metadata instead of the record.
+	metadata bool
+}
+
+// metadataAccessorKey is the first key of accessors of the event metadata.
+const metadataAccessorKey = "_metadata"
+
+func parseNameTemplate(tmpl string) (*nameTemplate, error) {
+	t := &nameTemplate{}
+	var literal strings.Builder
+
+	for i := 0; i < len(tmpl); {
+		if tmpl[i] != '$' {
+			literal.WriteByte(tmpl[i])
+			i++
+			continue
+		}
+
+		keys, n, err := parseRecordAccessor(tmpl[i:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template %q: %v", tmpl, err)
+		}
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{literal: literal.String()})
+			literal.Reset()
+		}
+		part := templatePart{keys: keys}
+		if keys[0] == metadataAccessorKey {
+			if len(keys) == 1 {
+				return nil, fmt.Errorf("Invalid template %q: metadata key is missing after '$%s'", tmpl, metadataAccessorKey)
+			}
+			part = templatePart{keys: keys[1:], metadata: true}
+		}
+		t.parts = append(t.parts, part)
+		i += n
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, templatePart{literal: literal.String()})
+	}
+
+	return t, nil
+}
+
+// parseRecordAccessor parses "$key['sub']['subsub']" at the head of s,
+// and returns the key path and the number of consumed bytes.
+func parseRecordAccessor(s string) ([]string, int, error) {
+	i := 1
+	for i < len(s) && isAccessorKeyChar(s[i]) {
+		i++
+	}
+	if i == 1 {
+		return nil, 0, fmt.Errorf("record key is missing after '$'")
+	}
+	keys := []string{s[1:i]}
+
+	for i+1 < len(s) && s[i] == '[' && (s[i+1] == '\'' || s[i+1] == '"') {
+		quote := s[i+1]
+		end := strings.IndexByte(s[i+2:], quote)
+		if end < 0 || i+2+end+1 >= len(s) || s[i+2+end+1] != ']' {
+			return nil, 0, fmt.Errorf("unterminated subkey at %q", s[i:])
+		}
+		keys = append(keys, s[i+2:i+2+end])
+		i += 2 + end + 2
+	}
+
+	return keys, i, nil
+}
+
+// parseFieldKey parses the value of an option which names a record field,
+// either a top level key such as "log" or a record accessor such as
+// "$kubernetes['labels']".
+func parseFieldKey(option, key string) ([]string, error) {
+	if key[0] != '$' {
+		return []string{key}, nil
+	}
+	keys, n, err := parseRecordAccessor(key)
+	if err != nil || n != len(key) {
+		return nil, fmt.Errorf("Invalid %s: %s", option, key)
+	}
+	return keys, nil
+}
+
+func isAccessorKeyChar(c byte) bool {
+	return c == '_' ||
+		('a' <= c && c <= 'z') ||
+		('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9')
+}
+
+// resolve renders the template with the record and the event metadata,
+// which may be nil. It returns false when any referenced key is missing
+// or is not a scalar value.
+func (t *nameTemplate) resolve(record, metadata map[interface{}]interface{}) (string, bool) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.keys == nil {
+			b.WriteString(part.literal)
+			continue
+		}
+		fields := record
+		if part.metadata {
+			fields = metadata
+		}
+		value, ok := lookupRecord(fields, part.keys)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(value)
+	}
+	return b.String(), true
+}
+
+// lookupField returns the value at the key path.
+func lookupField(record map[interface{}]interface{}, keys []string) (interface{}, bool) {
+	var current interface{} = record
+	for _, key := range keys {
+		m, ok := current.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func lookupRecord(record map[interface{}]interface{}, keys []string) (string, bool) {
+	current, ok := lookupField(record, keys)
+	if !ok {
+		return "", false
+	}
+
+	var value string
+	switch v := current.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	case map[interface{}]interface{}, []interface{}, nil:
+		return "", false
+	default:
+		value = fmt.Sprint(v)
+	}
+	if value == "" {
+		return "", false
+	}
+	return value, true
+}
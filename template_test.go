@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	tmpl, err := parseNameTemplate("/eks/$kubernetes['namespace_name']/$tag")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, []templatePart{
+		{literal: "/eks/"},
+		{keys: []string{"kubernetes", "namespace_name"}},
+		{literal: "/"},
+		{keys: []string{"tag"}},
+	}, tmpl.parts)
+
+	tmpl, err = parseNameTemplate(`$a["b"]['c']`)
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, []templatePart{{keys: []string{"a", "b", "c"}}}, tmpl.parts)
+
+	tmpl, err = parseNameTemplate("$_metadata['stream']-$stream")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+	assert.Equal(t, []templatePart{
+		{keys: []string{"stream"}, metadata: true},
+		{literal: "-"},
+		{keys: []string{"stream"}},
+	}, tmpl.parts)
+	name, ok := tmpl.resolve(
+		map[interface{}]interface{}{"stream": "stdout"},
+		map[interface{}]interface{}{"stream": []byte("stderr")})
+	assert.True(t, ok)
+	assert.Equal(t, "stderr-stdout", name)
+	_, ok = tmpl.resolve(map[interface{}]interface{}{"stream": "stdout"}, nil)
+	assert.False(t, ok)
+
+	_, err = parseNameTemplate("$_metadata")
+	assert.Error(t, err)
+	_, err = parseNameTemplate("/eks/$")
+	assert.Error(t, err)
+	_, err = parseNameTemplate("$kubernetes['namespace_name'")
+	assert.Error(t, err)
+}
+
+func TestNameTemplateResolve(t *testing.T) {
+	tmpl, err := parseNameTemplate("/eks/$kubernetes['namespace_name']-$level")
+	if err != nil {
+		t.Fatalf("failed test %#v", err)
+	}
+
+	record := map[interface{}]interface{}{
+		"kubernetes": map[interface{}]interface{}{
+			"namespace_name": []byte("default"),
+		},
+		"level": 3,
+	}
+	name, ok := tmpl.resolve(record, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "/eks/default-3", name)
+
+	_, ok = tmpl.resolve(map[interface{}]interface{}{"level": "info"}, nil)
+	assert.False(t, ok)
+
+	_, ok = tmpl.resolve(map[interface{}]interface{}{
+		"kubernetes": map[interface{}]interface{}{"namespace_name": ""},
+		"level":      "info",
+	}, nil)
+	assert.False(t, ok)
+}
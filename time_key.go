@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type timeFormatKind int
+
+const (
+	timeFormatRFC3339 timeFormatKind = iota
+	timeFormatEpochSeconds
+	timeFormatEpochMillis
+	timeFormatEpochNanos
+	timeFormatLayout
+)
+
+// timeKey is the record field which holds the timestamp of the event.
+type timeKey struct {
+	name   string
+	keys   []string
+	kind   timeFormatKind
+	layout string
+	// remove removes the field from the record before it is formatted.
+	remove bool
+}
+
+// getTimeKeyConfig returns nil when TimeKey is not specified. format is
+// "rfc3339", "epoch", "epoch_millis", "epoch_nanos" or a strftime pattern
+// such as "%Y-%m-%d %H:%M:%S".
+func getTimeKeyConfig(key, format, remove string) (*timeKey, error) {
+	if key == "" {
+		if format != "" {
+			return nil, fmt.Errorf("TimeFormat requires TimeKey")
+		}
+		return nil, nil
+	}
+	keys, err := parseFieldKey("TimeKey", key)
+	if err != nil {
+		return nil, err
+	}
+	conf := &timeKey{name: key, keys: keys}
+
+	switch strings.ToLower(format) {
+	case "", "rfc3339":
+		conf.kind = timeFormatRFC3339
+	case "epoch", "epoch_seconds":
+		conf.kind = timeFormatEpochSeconds
+	case "epoch_millis":
+		conf.kind = timeFormatEpochMillis
+	case "epoch_nanos":
+		conf.kind = timeFormatEpochNanos
+	default:
+		layout, err := strftimeLayout(format)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TimeFormat: %s: %v", format, err)
+		}
+		conf.kind = timeFormatLayout
+		conf.layout = layout
+	}
+
+	if remove != "" {
+		if conf.remove, err = strconv.ParseBool(remove); err != nil {
+			return nil, fmt.Errorf("Invalid RemoveTimeKey: %s", remove)
+		}
+	}
+	return conf, nil
+}
+
+// strftimeConversions maps strftime conversions to the Go layout.
+var strftimeConversions = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'j': "002",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'z': "-0700",
+	'Z': "MST",
+	'T': "15:04:05",
+	'F': "2006-01-02",
+	'D': "01/02/06",
+	'%': "%",
+}
+
+// strftimeLayout converts a strftime pattern to a Go layout. "%L" is the
+// fraction of a second after '.', e.g. "%H:%M:%S.%L".
+func strftimeLayout(format string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("trailing '%%'")
+		}
+		i++
+		if format[i] == 'L' {
+			// The fraction after the seconds is parsed without a layout.
+			layout := b.String()
+			if !strings.HasSuffix(layout, "05.") {
+				return "", fmt.Errorf("%%L must follow \"%%S.\"")
+			}
+			b.Reset()
+			b.WriteString(strings.TrimSuffix(layout, "."))
+			continue
+		}
+		conversion, ok := strftimeConversions[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported conversion %%%c", format[i])
+		}
+		b.WriteString(conversion)
+	}
+	return b.String(), nil
+}
+
+// timestamp returns the time of the field. It returns false when the field
+// is missing or cannot be parsed.
+func (k *timeKey) timestamp(record map[interface{}]interface{}) (time.Time, bool) {
+	value, ok := lookupField(record, k.keys)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	var s string
+	var number float64
+	isNumber := false
+	switch v := value.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case []byte:
+		s = strings.TrimSpace(string(v))
+	case int64:
+		return k.epoch(v)
+	case int:
+		return k.epoch(int64(v))
+	case uint64:
+		if v > math.MaxInt64 {
+			return time.Time{}, false
+		}
+		return k.epoch(int64(v))
+	case float64:
+		number, isNumber = v, true
+	case float32:
+		number, isNumber = float64(v), true
+	default:
+		return time.Time{}, false
+	}
+
+	switch k.kind {
+	case timeFormatRFC3339:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t, err == nil
+	case timeFormatLayout:
+		t, err := time.Parse(k.layout, s)
+		return t, err == nil
+	}
+
+	if !isNumber {
+		// Integers are parsed as they are, so that nanoseconds keep their
+		// precision.
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return k.epoch(n)
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		number = f
+	}
+
+	switch k.kind {
+	case timeFormatEpochSeconds:
+		return epochFloat(number, time.Second)
+	case timeFormatEpochMillis:
+		return epochFloat(number, time.Millisecond)
+	default:
+		return epochFloat(number, time.Nanosecond)
+	}
+}
+
+// epochFloat returns the time of a float epoch in unit. It is rounded to
+// microseconds unless unit is smaller, because a float64 of the current
+// epoch seconds is precise to about a microsecond, and 1552212672.345 would
+// otherwise become .344. It returns false when the time is out of range.
+func epochFloat(number float64, unit time.Duration) (time.Time, bool) {
+	precision := time.Microsecond
+	if unit < precision {
+		precision = unit
+	}
+	number = math.Round(number * float64(unit/precision))
+	if math.IsNaN(number) || math.Abs(number) >= float64(math.MaxInt64/int64(precision)) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(number)*int64(precision)), true
+}
+
+// epoch returns the time of an integer in the unit of the format. It
+// returns false when the format is not an epoch.
+func (k *timeKey) epoch(n int64) (time.Time, bool) {
+	switch k.kind {
+	case timeFormatEpochSeconds:
+		return time.Unix(n, 0), true
+	case timeFormatEpochMillis:
+		return time.Unix(n/1000, n%1000*int64(time.Millisecond)), true
+	case timeFormatEpochNanos:
+		return time.Unix(0, n), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// withoutField returns a copy of record without the field at the key
+// path. The maps on the path are copied, so that record is not modified.
+func withoutField(record map[interface{}]interface{}, keys []string) map[interface{}]interface{} {
+	copied := make(map[interface{}]interface{}, len(record))
+	for key, value := range record {
+		copied[key] = value
+	}
+	if len(keys) == 1 {
+		delete(copied, keys[0])
+		return copied
+	}
+	if nested, ok := copied[keys[0]].(map[interface{}]interface{}); ok {
+		copied[keys[0]] = withoutField(nested, keys[1:])
+	}
+	return copied
+}
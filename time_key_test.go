@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTimeKeyConfig(t *testing.T) {
+	conf, err := getTimeKeyConfig("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, conf)
+
+	conf, err = getTimeKeyConfig("time", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, timeFormatRFC3339, conf.kind)
+	assert.False(t, conf.remove)
+
+	conf, err = getTimeKeyConfig("$log['ts']", "epoch_millis", "true")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"log", "ts"}, conf.keys)
+	assert.Equal(t, timeFormatEpochMillis, conf.kind)
+	assert.True(t, conf.remove)
+
+	conf, err = getTimeKeyConfig("time", "%d/%b/%Y:%H:%M:%S %z", "")
+	assert.NoError(t, err)
+	assert.Equal(t, timeFormatLayout, conf.kind)
+	assert.Equal(t, "02/Jan/2006:15:04:05 -0700", conf.layout)
+
+	for _, tc := range [][3]string{
+		{"", "epoch", ""},
+		{"$", "", ""},
+		{"time", "%Q", ""},
+		{"time", "%Y-%m-%d %", ""},
+		{"time", "%H:%M:%L", ""},
+		{"time", "", "sometimes"},
+	} {
+		_, err = getTimeKeyConfig(tc[0], tc[1], tc[2])
+		assert.Error(t, err, "%v", tc)
+	}
+}
+
+func TestTimeKeyTimestamp(t *testing.T) {
+	expected := time.Date(2019, time.March, 10, 10, 11, 12, 345000000, time.UTC)
+	for _, tc := range []struct {
+		format string
+		value  interface{}
+	}{
+		{"", "2019-03-10T10:11:12.345Z"},
+		{"rfc3339", []byte("2019-03-10T19:11:12.345+09:00")},
+		{"epoch", int64(1552212672)},
+		{"epoch", 1552212672.345},
+		{"epoch", "1552212672.345"},
+		{"epoch_millis", uint64(1552212672345)},
+		{"epoch_millis", "1552212672345"},
+		{"epoch_nanos", int64(1552212672345000000)},
+		{"epoch_nanos", "1552212672345000000"},
+		{"%Y-%m-%d %H:%M:%S.%L", "2019-03-10 10:11:12.345"},
+		{"%d/%b/%Y:%H:%M:%S %z", "10/Mar/2019:19:11:12 +0900"},
+	} {
+		conf, err := getTimeKeyConfig("time", tc.format, "")
+		if err != nil {
+			t.Fatalf("failed test %#v", err)
+		}
+		ts, ok := conf.timestamp(map[interface{}]interface{}{"time": tc.value})
+		assert.True(t, ok, "%s %v", tc.format, tc.value)
+		if tc.format == "epoch" && tc.value == int64(1552212672) ||
+			tc.format == "%d/%b/%Y:%H:%M:%S %z" {
+			assert.True(t, expected.Truncate(time.Second).Equal(ts), "%s %v: %v", tc.format, tc.value, ts)
+		} else {
+			assert.Equal(t, aws.TimeUnixMilli(expected), aws.TimeUnixMilli(ts), "%s %v: %v", tc.format, tc.value, ts)
+		}
+	}
+
+	for _, tc := range []struct {
+		format string
+		record map[interface{}]interface{}
+	}{
+		{"", map[interface{}]interface{}{"message": "no time"}},
+		{"", map[interface{}]interface{}{"time": "yesterday"}},
+		{"", map[interface{}]interface{}{"time": int64(1552212672)}},
+		{"epoch", map[interface{}]interface{}{"time": "2019-03-10"}},
+		{"epoch", map[interface{}]interface{}{"time": map[interface{}]interface{}{}}},
+		{"epoch_nanos", map[interface{}]interface{}{"time": uint64(1 << 63)}},
+		{"epoch", map[interface{}]interface{}{"time": 1e300}},
+	} {
+		conf, _ := getTimeKeyConfig("time", tc.format, "")
+		_, ok := conf.timestamp(tc.record)
+		assert.False(t, ok, "%s %v", tc.format, tc.record)
+	}
+}
+
+func TestWithoutField(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"time": "2019-03-10T10:11:12Z",
+		"log": map[interface{}]interface{}{
+			"ts":  "2019-03-10T10:11:12Z",
+			"msg": "message",
+		},
+	}
+	assert.Equal(t, map[interface{}]interface{}{
+		"log": record["log"],
+	}, withoutField(record, []string{"time"}))
+	assert.Equal(t, map[interface{}]interface{}{
+		"time": "2019-03-10T10:11:12Z",
+		"log":  map[interface{}]interface{}{"msg": "message"},
+	}, withoutField(record, []string{"log", "ts"}))
+	// The record itself is not modified.
+	assert.Len(t, record, 2)
+	assert.Len(t, record["log"], 2)
+}
+
+func TestPluginFlusherWithTimeKey(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		params:           map[string]string{"TimeKey": "@timestamp", "RemoveTimeKey": "true"},
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+
+	ingested := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	testplugin.addrecord(0, output.FLBTime{Time: ingested}, map[interface{}]interface{}{"@timestamp": "2019-03-10T09:00:00.5Z", "msg": "first"})
+	testplugin.addrecord(0, output.FLBTime{Time: ingested}, map[interface{}]interface{}{"@timestamp": "invalid", "msg": "second"})
+	assert.Equal(t, output.FLB_OK, flush(nil, nil, 0, ""))
+	assert.Len(t, testplugin.events, 2)
+	assert.Equal(t, uint64(1), pluginContexts[testplugin.contextID].timeKeyMisses)
+
+	var parsed map[string]interface{}
+	json.Unmarshal(testplugin.events[0].data, &parsed)
+	assert.Equal(t, map[string]interface{}{"msg": "first"}, parsed)
+	// The events are sorted by the timestamps.
+	assert.Equal(t, "first", parsed["msg"])
+	// The field which cannot be parsed is kept.
+	parsed = nil
+	json.Unmarshal(testplugin.events[1].data, &parsed)
+	assert.Equal(t, map[string]interface{}{"@timestamp": "invalid", "msg": "second"}, parsed)
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+}
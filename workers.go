@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+)
+
+const (
+	defaultWorkers = 1
+	maxWorkers     = 64
+)
+
+// getWorkersConfig returns the number of goroutines which send the events
+// of different logStreams concurrently.
+func getWorkersConfig(workers string) (int, error) {
+	n, err := cwlogs.ParseIntParameter("Workers", workers, defaultWorkers)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > maxWorkers {
+		return 0, fmt.Errorf("Invalid Workers: %s (must be between 1 and %d)", workers, maxWorkers)
+	}
+	return n, nil
+}
+
+// job is the logStreams of a chunk which a goroutine owns.
+type job struct {
+	chunk  *chunk
+	tokens []cwlogs.UpdateToken
+	result chan<- streamResult
+}
+
+type streamResult struct {
+	token   cwlogs.UpdateToken
+	dropped bool
+	err     error
+}
+
+// workerPool sends the events of each logStream from the goroutine which
+// owns it, so that the events and the sequence token chain of a logStream
+// stay in order while different logStreams are sent concurrently.
+type workerPool struct {
+	client *cwlogs.Client
+	jobs   []chan *job
+}
+
+func newWorkerPool(client *cwlogs.Client, n int) *workerPool {
+	p := &workerPool{client: client}
+	for i := 0; i < n; i++ {
+		jobs := make(chan *job, 1)
+		p.jobs = append(p.jobs, jobs)
+		go p.work(jobs)
+	}
+	return p
+}
+
+func (p *workerPool) work(jobs <-chan *job) {
+	for j := range jobs {
+		for _, token := range j.tokens {
+			dropped, err := sendStream(p.client, j.chunk.id, token, j.chunk.events[token])
+			j.result <- streamResult{token: token, dropped: dropped, err: err}
+		}
+	}
+}
+
+// owner returns the index of the goroutine which sends the logStream.
+func (p *workerPool) owner(token cwlogs.UpdateToken) int {
+	h := fnv.New32a()
+	h.Write([]byte(token.LogGroup))
+	h.Write([]byte{0})
+	h.Write([]byte(token.LogStream))
+	return int(h.Sum32() % uint32(len(p.jobs)))
+}
+
+// send sends the events of c, and waits for all the logStreams. It returns
+// the error of the first logStream in c which failed.
+func (p *workerPool) send(c *chunk) (bool, error) {
+	results := make(chan streamResult, len(c.destinations))
+	jobs := make(map[int]*job)
+	for _, token := range c.destinations {
+		i := p.owner(token)
+		if jobs[i] == nil {
+			jobs[i] = &job{chunk: c, result: results}
+		}
+		jobs[i].tokens = append(jobs[i].tokens, token)
+	}
+	for i, j := range jobs {
+		p.jobs[i] <- j
+	}
+
+	errs := make(map[cwlogs.UpdateToken]error)
+	dropped := false
+	for range c.destinations {
+		result := <-results
+		dropped = dropped || result.dropped
+		if result.err != nil {
+			errs[result.token] = result.err
+		}
+	}
+	for _, token := range c.destinations {
+		if err, ok := errs[token]; ok {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// close stops the goroutines. p may be nil.
+func (p *workerPool) close() {
+	if p == nil {
+		return
+	}
+	for _, jobs := range p.jobs {
+		close(jobs)
+	}
+}
+
+// sendStream makes one or more PutLogEvents calls for a (logGroup,
+// logStream) pair of the chunk.
+func sendStream(client *cwlogs.Client, chunk cwlogs.ChunkID, token cwlogs.UpdateToken, events []*cloudwatchlogs.InputLogEvent) (bool, error) {
+	client.PrepareLogGroup(token.LogGroup)
+	client.PrepareLogStream(token.LogGroup, token.LogStream)
+	return client.PutLogEvents(chunk, token, events)
+}
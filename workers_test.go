@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cosmo0920/fluent-bit-go-cloudwatch-logs/cwlogs"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWorkersConfig(t *testing.T) {
+	n, err := getWorkersConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = getWorkersConfig("8")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+
+	for _, invalid := range []string{"0", "-1", "65", "many"} {
+		_, err = getWorkersConfig(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPluginFlusherWithWorkers(t *testing.T) {
+	cloudwatchLogsCreds = &testCloudwatchLogsCredential{}
+	testplugin := &testFluentPlugin{
+		credential:       "examplecredentials",
+		logGroupName:     "examplegroup",
+		logStreamName:    "examplestream",
+		logStreamTmpl:    "$stream",
+		region:           "exampleregion",
+		autoCreateStream: "true",
+		params:           map[string]string{"Workers": "4"},
+		putStarted:       make(chan struct{}, 10),
+		putGate:          make(chan struct{}),
+	}
+	plugin = testplugin
+	assert.Equal(t, output.FLB_OK, FLBPluginInit(nil))
+	pctx := pluginContexts[testplugin.contextID]
+
+	// Two logStreams which are owned by different goroutines.
+	first := cwlogs.UpdateToken{LogGroup: "examplegroup", LogStream: "stream-0"}
+	second := first
+	for i := 1; pctx.workers.owner(second) == pctx.workers.owner(first); i++ {
+		second.LogStream = fmt.Sprintf("stream-%d", i)
+	}
+
+	ts := time.Date(2019, time.March, 10, 10, 11, 12, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		for _, stream := range []string{first.LogStream, second.LogStream} {
+			testplugin.addrecord(0, output.FLBTime{Time: ts.Add(time.Duration(i) * time.Second)},
+				map[interface{}]interface{}{"stream": stream, "seq": i})
+		}
+	}
+
+	res := make(chan int)
+	go func() { res <- flush(nil, nil, 0, "") }()
+
+	// Both logStreams are being sent at the same time.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-testplugin.putStarted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("logStreams were not sent concurrently")
+		}
+	}
+	close(testplugin.putGate)
+	assert.Equal(t, output.FLB_OK, <-res)
+
+	// The events of each logStream are in order.
+	seqs := make(map[string][]float64)
+	for _, e := range testplugin.events {
+		var parsed map[string]interface{}
+		json.Unmarshal(e.data, &parsed)
+		assert.Equal(t, e.logStreamName, parsed["stream"])
+		seqs[e.logStreamName] = append(seqs[e.logStreamName], parsed["seq"].(float64))
+	}
+	assert.Equal(t, []float64{0, 1, 2}, seqs[first.LogStream])
+	assert.Equal(t, []float64{0, 1, 2}, seqs[second.LogStream])
+	assert.Equal(t, output.FLB_OK, FLBPluginExitCtx(nil))
+}